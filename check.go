@@ -0,0 +1,155 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	configuration "github.com/piersharding/elktail/configuration"
+	"golang.org/x/crypto/ssh"
+)
+
+const checkTimeout = 10 * time.Second
+
+// A single stage of the connection check
+type checkStep struct {
+	name string
+	run  func() error
+}
+
+// RunConnectionCheck exercises the connection path to elasticsearch stage by stage (DNS, TCP, SSH tunnel, TLS,
+// authentication and a trivial search), printing pass/fail and timing for each stage to out. Stages depend on each
+// other, so the check stops at the first failure. The tunnel (nil if none) must not be started yet, the check
+// establishes it. Returns true if all stages passed.
+func RunConnectionCheck(config *configuration.Configuration, tunnel *SSHTunnel, out io.Writer) bool {
+	target, err := url.Parse(targetUrl(config))
+	if err != nil {
+		printCheckResult(out, "Parse URL", 0, err)
+		return false
+	}
+	host := target.Hostname()
+	port := target.Port()
+	if port == "" && target.Scheme == "https" {
+		port = "443"
+	} else if port == "" {
+		port = "80"
+	}
+	hostPort := net.JoinHostPort(host, port)
+	//connection to the target, either direct or as a channel of the SSH connection
+	dial := func() (net.Conn, error) {
+		return net.DialTimeout("tcp", hostPort, checkTimeout)
+	}
+
+	steps := []checkStep{}
+	if tunnel != nil {
+		//the target host is resolved by the SSH server, locally only the SSH server has to resolve
+		var client *ssh.Client
+		steps = append(steps, checkStep{"DNS lookup", func() error {
+			return lookupCheck(tunnel.Server.Host)
+		}})
+		steps = append(steps, checkStep{"SSH connect", func() error {
+			client, err = tunnel.connect()
+			return err
+		}})
+		dial = func() (net.Conn, error) {
+			return client.Dial("tcp", tunnel.Remote.String())
+		}
+		steps = append(steps, checkStep{"SSH channel", func() error {
+			return closeCheck(dial())
+		}})
+	} else {
+		steps = append(steps, checkStep{"DNS lookup", func() error {
+			return lookupCheck(host)
+		}})
+		steps = append(steps, checkStep{"TCP connect", func() error {
+			return closeCheck(dial())
+		}})
+	}
+	if target.Scheme == "https" {
+		steps = append(steps, checkStep{"TLS handshake", func() error {
+			tlsConfig, err := loadTLSConfig(config)
+			if err != nil {
+				return err
+			}
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{}
+			}
+			tlsConfig.ServerName = host
+			conn, err := dial()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(checkTimeout))
+			return tls.Client(conn, tlsConfig).Handshake()
+		}})
+	}
+	if tunnel != nil {
+		steps = append(steps, checkStep{"Tunnel listen", func() error {
+			listener, err := net.Listen("tcp", tunnel.Local.String())
+			if err != nil {
+				return err
+			}
+			go tunnel.serve(listener)
+			return nil
+		}})
+	}
+	if config.User != "" {
+		steps = append(steps, checkStep{"Authentication", func() error {
+			token := AuthToken{config: config}
+			return token.Authenticate()
+		}})
+	}
+	steps = append(steps, checkStep{"Search", func() error {
+		client, err := newElasticClient(config)
+		if err != nil {
+			return err
+		}
+		result, err := newTailWithClient(config, client).initialSearch(1)
+		if err == nil {
+			Info.Printf("Search matched %d entries", result.TotalHits())
+		}
+		return err
+	}})
+
+	for _, step := range steps {
+		start := time.Now()
+		err := step.run()
+		printCheckResult(out, step.name, time.Since(start), err)
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func lookupCheck(host string) error {
+	addresses, err := net.LookupHost(host)
+	if err == nil {
+		Info.Printf("%s resolved to %v", host, addresses)
+	}
+	return err
+}
+
+func closeCheck(conn net.Conn, err error) error {
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func printCheckResult(out io.Writer, name string, elapsed time.Duration, err error) {
+	if err != nil {
+		fmt.Fprintf(out, "[FAIL] %-15s %6dms  %s\n", name, elapsed.Milliseconds(), err)
+	} else {
+		fmt.Fprintf(out, "[ OK ] %-15s %6dms\n", name, elapsed.Milliseconds())
+	}
+}
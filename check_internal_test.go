@@ -0,0 +1,79 @@
+/* Copyright (C) 2022 Piers Harding
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	configuration "github.com/piersharding/elktail/configuration"
+	tu "github.com/piersharding/elktail/testutils"
+	"golang.org/x/crypto/ssh"
+)
+
+func newCheckConfiguration(url string) *configuration.Configuration {
+	config := new(configuration.Configuration)
+	config.SearchTarget.Url = url
+	config.SearchTarget.IndexPattern = "logs-*"
+	config.QueryDefinition.TimestampField = "@timestamp"
+	return config
+}
+
+func TestRunConnectionCheck(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"responses":[{"status":200,"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"1",` +
+			`"_source":{"@timestamp":"2022-01-01T00:00:00.000Z","message":"started"}}]}}]}`))
+	}))
+	defer server.Close()
+	config := newCheckConfiguration(server.URL)
+
+	var out bytes.Buffer
+	if !RunConnectionCheck(config, nil, &out) {
+		tu.Fail(t, "Expected check to pass: "+out.String())
+	}
+	for _, stage := range []string{"[ OK ] DNS lookup", "[ OK ] TCP connect", "[ OK ] Search"} {
+		if !strings.Contains(out.String(), stage) {
+			tu.Fail(t, "Expected stage "+stage+" in: "+out.String())
+		}
+	}
+
+	//the stages after the failed one are not run
+	server.Close()
+	out.Reset()
+	if RunConnectionCheck(config, nil, &out) {
+		tu.Fail(t, "Expected check to fail for closed server")
+	}
+	if !strings.Contains(out.String(), "[FAIL] TCP connect") || strings.Contains(out.String(), "Search") {
+		tu.Fail(t, "Expected check to stop at failed TCP connect: "+out.String())
+	}
+}
+
+func TestRunConnectionCheckTunnel(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	config := newCheckConfiguration("http://elasticsearch.internal:9200")
+	config.SearchTarget.TunnelUrl = "http://localhost:9199"
+	tunnel := &SSHTunnel{
+		Local:  &Endpoint{Host: "localhost", Port: 9199},
+		Server: startTestSSHServer(t),
+		Remote: &Endpoint{Host: "elasticsearch.internal", Port: 9200},
+		Config: &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()},
+	}
+
+	//the test server accepts the SSH connection, but rejects forwarding to the target
+	var out bytes.Buffer
+	if RunConnectionCheck(config, tunnel, &out) {
+		tu.Fail(t, "Expected check to fail for rejected channel")
+	}
+	if !strings.Contains(out.String(), "[ OK ] SSH connect") || !strings.Contains(out.String(), "[FAIL] SSH channel") {
+		tu.Fail(t, "Expected SSH connect to pass and SSH channel to fail: "+out.String())
+	}
+}
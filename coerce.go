@@ -0,0 +1,125 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Types fields are coerced to with --coerce
+const (
+	coerceInt    = "int"
+	coerceFloat  = "float"
+	coerceBool   = "bool"
+	coerceString = "string"
+)
+
+type fieldCoercion struct {
+	field    string
+	typeName string
+}
+
+// ParseCoercions parses field coercions given in field:type format
+func ParseCoercions(definitions []string) ([]fieldCoercion, error) {
+	result := make([]fieldCoercion, 0, len(definitions))
+	for _, definition := range definitions {
+		field, typeName, err := parseFieldValue(definition)
+		if err != nil {
+			return nil, err
+		}
+		typeName = strings.ToLower(strings.TrimSpace(typeName))
+		switch typeName {
+		case coerceInt, coerceFloat, coerceBool, coerceString:
+		default:
+			return nil, fmt.Errorf("%s is not one of %s, %s, %s or %s", typeName, coerceInt, coerceFloat, coerceBool,
+				coerceString)
+		}
+		result = append(result, fieldCoercion{field: strings.TrimSpace(field), typeName: typeName})
+	}
+	return result, nil
+}
+
+// Replaces values of the fields with values of the coerced type, so they render as that type in any output format.
+// Values that can't be coerced are kept as they are.
+func coerceFields(entry map[string]interface{}, coercions []fieldCoercion) {
+	for _, coercion := range coercions {
+		parent, key, ok := lookupField(entry, coercion.field)
+		if !ok || parent[key] == nil {
+			continue
+		}
+		coerced, err := coerceValue(parent[key], coercion.typeName)
+		if err != nil {
+			Trace.Printf("Failed coercing %s field to %s, keeping the value: %s", coercion.field, coercion.typeName, err)
+			continue
+		}
+		parent[key] = coerced
+	}
+}
+
+// Numbers are returned as json.Number, so they render the same in text and ndjson output. Fraction is dropped
+// when coercing to int.
+func coerceValue(value interface{}, typeName string) (interface{}, error) {
+	switch typeName {
+	case coerceInt:
+		if number, ok := value.(json.Number); ok {
+			if _, err := number.Int64(); err == nil {
+				//already an integer, converting it to float64 could round big ones
+				return number, nil
+			}
+		}
+		number, err := numberValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatFloat(math.Trunc(number), 'f', -1, 64)), nil
+	case coerceFloat:
+		number, err := numberValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatFloat(number, 'f', -1, 64)), nil
+	case coerceBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(v))
+		}
+		number, err := numberValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return number != 0, nil
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		js, err := json.Marshal(value)
+		return string(js), err
+	}
+	return fmt.Sprintf("%v", value), nil
+}
+
+// Returns the value as float64, parsing strings and converting booleans to 1 or 0
+func numberValue(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Float64()
+	case float64:
+		return v, nil
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(v), 64)
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("%v is not a number", value)
+}
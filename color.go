@@ -0,0 +1,104 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+const (
+	ansiReset   = "\033[0m"
+	ansiRed     = "\033[31m"
+	ansiGreen   = "\033[32m"
+	ansiYellow  = "\033[33m"
+	ansiBlue    = "\033[34m"
+	ansiMagenta = "\033[35m"
+	ansiCyan    = "\033[36m"
+	ansiDim     = "\033[2m"
+)
+
+// Colors of query labels by name
+var labelColors = map[string]string{
+	"red":     ansiRed,
+	"green":   ansiGreen,
+	"yellow":  ansiYellow,
+	"blue":    ansiBlue,
+	"magenta": ansiMagenta,
+	"cyan":    ansiCyan,
+}
+
+// Fields checked (in order) for the log level of an entry when coloring output lines
+var levelFields = []string{"level", "log.level", "severity", "loglevel"}
+
+// Decides whether colored output should be written to the file. This is the single place where --color
+// auto|always|never is interpreted, so all colorized output follows the same rules: always and never are
+// obeyed unconditionally, auto colors only terminals (and respects NO_COLOR and TERM=dumb).
+func colorEnabled(mode string, file *os.File) (bool, error) {
+	switch mode {
+	case colorAlways:
+		return true, nil
+	case colorNever:
+		return false, nil
+	case colorAuto, "":
+		if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+			return false, nil
+		}
+		return isTerminal(file), nil
+	}
+	return false, fmt.Errorf("%s is not one of auto, always or never", mode)
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Returns ANSI color for the log level of the entry, or empty string if the level has no color
+func levelColor(entry map[string]interface{}) string {
+	for _, field := range levelFields {
+		level, err := EvaluateExpression(entry, field)
+		if err != nil {
+			continue
+		}
+		switch strings.ToUpper(level) {
+		case "FATAL", "CRITICAL", "ERROR", "ERR":
+			return ansiRed
+		case "WARN", "WARNING":
+			return ansiYellow
+		case "DEBUG", "TRACE":
+			return ansiDim
+		}
+		return ""
+	}
+	return ""
+}
+
+// Colors the output line according to log level of the entry
+func colorizeLine(line string, entry map[string]interface{}) string {
+	if color := levelColor(entry); color != "" {
+		return color + line + ansiReset
+	}
+	return line
+}
+
+// Formats the prefix of entries of a named query, e.g. "[errors] ", colored if colors are enabled
+func formatLabel(name string, color string, colored bool) string {
+	if colored && labelColors[color] != "" {
+		return labelColors[color] + "[" + name + "]" + ansiReset + " "
+	}
+	return "[" + name + "] "
+}
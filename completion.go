@@ -0,0 +1,200 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	configuration "github.com/piersharding/elktail/configuration"
+	"github.com/urfave/cli"
+	"golang.org/x/net/context"
+)
+
+var indicesCacheFile = "indices.cache"
+
+const indicesCacheTTL = time.Hour
+
+// Flags whose values are completed dynamically, mapped to the argument of the completion command that lists them
+var dynamicFlagCompletions = map[string]string{
+	"index-pattern": "indices",
+	"profile":       "profiles",
+}
+
+// CompletionCommand is a hidden command that generates shell completion scripts for elktail's flags. Scripts call
+// back to it (e.g. "elktail completion indices staging") to complete flag values dynamically, passing the --profile
+// given on the command line, so values come from the server of that profile.
+func CompletionCommand(flags []cli.Flag) cli.Command {
+	return cli.Command{
+		Name:      "completion",
+		Usage:     "Generate shell completion script",
+		ArgsUsage: "bash|zsh|fish",
+		Hidden:    true,
+		Action: func(c *cli.Context) {
+			switch c.Args().First() {
+			case "bash":
+				fmt.Print(bashCompletion(flags))
+			case "zsh":
+				fmt.Print("autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion(flags))
+			case "fish":
+				fmt.Print(fishCompletion(flags))
+			case "indices":
+				for _, index := range completeIndices(c.Args().Get(1)) {
+					fmt.Println(index)
+				}
+			case "profiles":
+				profiles, _ := configuration.ListProfiles()
+				for _, profile := range profiles {
+					fmt.Println(profile)
+				}
+			default:
+				fmt.Fprintln(os.Stderr, "Usage: elktail completion bash|zsh|fish")
+				os.Exit(1)
+			}
+		},
+	}
+}
+
+// Returns short and long names of the flag (e.g. "i" and ["index-pattern"])
+func flagNames(flag cli.Flag) (short string, long []string) {
+	for _, name := range strings.Split(flag.GetName(), ",") {
+		name = strings.TrimSpace(name)
+		if strings.Contains(name, " ") {
+			continue
+		}
+		if len(name) == 1 {
+			short = name
+		} else {
+			long = append(long, name)
+		}
+	}
+	return short, long
+}
+
+// Returns the argument of the completion command that lists values for the flag, if flag has dynamic completion
+func dynamicFlagCompletion(long []string) (string, bool) {
+	for _, name := range long {
+		if completion, ok := dynamicFlagCompletions[name]; ok {
+			return completion, true
+		}
+	}
+	return "", false
+}
+
+func bashCompletion(flags []cli.Flag) string {
+	var options []string
+	var cases strings.Builder
+	for _, flag := range flags {
+		short, long := flagNames(flag)
+		var patterns []string
+		if short != "" {
+			patterns = append(patterns, "-"+short)
+		}
+		for _, name := range long {
+			patterns = append(patterns, "--"+name)
+		}
+		options = append(options, patterns...)
+		if completion, ok := dynamicFlagCompletion(long); ok {
+			fmt.Fprintf(&cases, "        %s)\n", strings.Join(patterns, "|"))
+			fmt.Fprintf(&cases, "            COMPREPLY=( $(compgen -W \"$(elktail completion %s \"$profile\" 2>/dev/null)\" -- \"$cur\") )\n", completion)
+			cases.WriteString("            return 0\n            ;;\n")
+		}
+	}
+	return "_elktail_complete() {\n" +
+		"    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n" +
+		"    local prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n" +
+		"    local profile=\"\" i\n" +
+		"    for (( i=1; i < COMP_CWORD - 1; i++ )); do\n" +
+		"        if [[ \"${COMP_WORDS[i]}\" == \"--profile\" ]]; then\n" +
+		"            profile=\"${COMP_WORDS[i+1]}\"\n" +
+		"        fi\n" +
+		"    done\n" +
+		"    case \"$prev\" in\n" + cases.String() + "    esac\n" +
+		"    if [[ \"$cur\" == -* ]]; then\n" +
+		"        COMPREPLY=( $(compgen -W \"" + strings.Join(options, " ") + "\" -- \"$cur\") )\n" +
+		"    fi\n" +
+		"}\n" +
+		"complete -o default -F _elktail_complete elktail\n"
+}
+
+func fishCompletion(flags []cli.Flag) string {
+	var script strings.Builder
+	//prints the --profile given on the command line, passed to dynamic completions
+	script.WriteString("function __elktail_profile\n" +
+		"    set -l tokens (commandline -opc)\n" +
+		"    for i in (seq 2 (math (count $tokens) - 1))\n" +
+		"        if test \"$tokens[$i]\" = --profile\n" +
+		"            echo $tokens[(math $i + 1)]\n" +
+		"        end\n" +
+		"    end\n" +
+		"end\n")
+	for _, flag := range flags {
+		short, long := flagNames(flag)
+		if short == "" && len(long) == 0 {
+			continue
+		}
+		script.WriteString("complete -c elktail")
+		if short != "" {
+			script.WriteString(" -s " + short)
+		}
+		for _, name := range long {
+			script.WriteString(" -l " + name)
+		}
+		if docFlag, ok := flag.(cli.DocGenerationFlag); ok {
+			if docFlag.TakesValue() {
+				script.WriteString(" -r")
+			}
+			script.WriteString(" -d '" + strings.Replace(docFlag.GetUsage(), "'", "\\'", -1) + "'")
+		}
+		if completion, ok := dynamicFlagCompletion(long); ok {
+			script.WriteString(" -x -a '(elktail completion " + completion + " (__elktail_profile) 2>/dev/null)'")
+		}
+		script.WriteString("\n")
+	}
+	return script.String()
+}
+
+// Lists indices for completion, using the cached list if it's recent enough. Otherwise indices are fetched from
+// the server in the saved configuration of the profile (default one if empty) and cached per profile.
+func completeIndices(profile string) []string {
+	if configuration.ValidateProfileName(profile) != nil {
+		return nil
+	}
+	cacheName := indicesCacheFile
+	if profile != "" {
+		cacheName = profile + "." + indicesCacheFile
+	}
+	cacheFile := userHomeDir() + string(os.PathSeparator) + confDir + string(os.PathSeparator) + cacheName
+	if info, err := os.Stat(cacheFile); err == nil && time.Since(info.ModTime()) < indicesCacheTTL {
+		if cached, err := ioutil.ReadFile(cacheFile); err == nil {
+			return strings.Fields(string(cached))
+		}
+	}
+
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	config, err := configuration.LoadProfile(profile)
+	if err != nil {
+		return nil
+	}
+	tail := NewTail(config)
+	result, err := tail.client.CatIndices().Do(context.TODO())
+	if err != nil {
+		return nil
+	}
+	indices := make([]string, 0, len(result))
+	for _, response := range result {
+		if !strings.HasPrefix(response.Index, ".") {
+			indices = append(indices, response.Index)
+		}
+	}
+	sort.Strings(indices)
+	ioutil.WriteFile(cacheFile, []byte(strings.Join(indices, "\n")+"\n"), 0600)
+	return indices
+}
@@ -0,0 +1,105 @@
+/* Copyright (C) 2022 Piers Harding
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	configuration "github.com/piersharding/elktail/configuration"
+	tu "github.com/piersharding/elktail/testutils"
+	"github.com/urfave/cli"
+)
+
+var completionTestFlags = []cli.Flag{
+	cli.StringFlag{Name: "index-pattern, i", Usage: "Index pattern"},
+	cli.StringFlag{Name: "profile", Usage: "Named profile"},
+	cli.BoolFlag{Name: "f", Usage: "Don't stop, follow the query"},
+}
+
+func TestBashCompletion(t *testing.T) {
+	script := bashCompletion(completionTestFlags)
+	for _, expected := range []string{
+		`compgen -W "-i --index-pattern --profile -f" -- "$cur"`,
+		"        -i|--index-pattern)\n" +
+			`            COMPREPLY=( $(compgen -W "$(elktail completion indices "$profile" 2>/dev/null)" -- "$cur") )`,
+		"        --profile)\n" +
+			`            COMPREPLY=( $(compgen -W "$(elktail completion profiles "$profile" 2>/dev/null)" -- "$cur") )`,
+		`profile="${COMP_WORDS[i+1]}"`,
+		"complete -o default -F _elktail_complete elktail\n",
+	} {
+		if !strings.Contains(script, expected) {
+			tu.Fail(t, "Expected bash completion to contain: "+expected)
+		}
+	}
+}
+
+func TestFishCompletion(t *testing.T) {
+	script := fishCompletion(completionTestFlags)
+	for _, expected := range []string{
+		"function __elktail_profile\n",
+		"complete -c elktail -s i -l index-pattern -r -d 'Index pattern' -x -a '(elktail completion indices (__elktail_profile) 2>/dev/null)'\n",
+		"complete -c elktail -l profile -r -d 'Named profile' -x -a '(elktail completion profiles (__elktail_profile) 2>/dev/null)'\n",
+		"complete -c elktail -s f -d 'Don\\'t stop, follow the query'\n",
+	} {
+		if !strings.Contains(script, expected) {
+			tu.Fail(t, "Expected fish completion to contain: "+expected)
+		}
+	}
+}
+
+func TestCompleteIndices(t *testing.T) {
+	home, err := ioutil.TempDir("", "elktail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	t.Setenv("HOME", home)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if strings.HasPrefix(r.URL.Path, "/_cat/indices") {
+			w.Write([]byte(`[{"index":"logs-2022.01.02"},{"index":".kibana"},{"index":"logs-2022.01.01"}]`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	staging := &configuration.Configuration{}
+	staging.SearchTarget.Url = server.URL
+	staging.SaveProfile("staging")
+	production := &configuration.Configuration{}
+	production.SearchTarget.Url = "http://127.0.0.1:1"
+	production.SaveDefault()
+
+	//indices come from the server of the profile and are cached per profile
+	tu.AssertEqualsString(t, "logs-2022.01.01,logs-2022.01.02", strings.Join(completeIndices("staging"), ","))
+	cached, err := ioutil.ReadFile(filepath.Join(home, confDir, "staging."+indicesCacheFile))
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, "logs-2022.01.01\nlogs-2022.01.02\n", string(cached))
+	server.Close()
+	tu.AssertEqualsString(t, "logs-2022.01.01,logs-2022.01.02", strings.Join(completeIndices("staging"), ","))
+
+	//stale cache of the default profile is refreshed from its server, which is not reachable
+	defaultCache := filepath.Join(home, confDir, indicesCacheFile)
+	ioutil.WriteFile(defaultCache, []byte("old-index\n"), 0600)
+	old := time.Now().Add(-2 * indicesCacheTTL)
+	os.Chtimes(defaultCache, old, old)
+	if indices := completeIndices(""); len(indices) != 0 {
+		tu.Fail(t, "Expected no indices for unreachable server, got "+strings.Join(indices, ","))
+	}
+	if completeIndices("../staging") != nil {
+		tu.Fail(t, "Expected no indices for invalid profile name")
+	}
+}
@@ -0,0 +1,128 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/net/context"
+)
+
+// Resolves the index pattern(s) to concrete index names using cat indices. If indices can not be fetched or
+// date math index names are used, the configured indices are returned as they are.
+func (tail *Tail) resolveIndices() []string {
+	for _, index := range tail.indices {
+		if isDateMathIndex(index) {
+			return tail.indices
+		}
+	}
+	result, err := tail.client.CatIndices().Index(strings.Join(tail.indices, ",")).Do(context.TODO())
+	if err != nil {
+		reportCatIndicesError(err)
+		return tail.indices
+	}
+	indices := make([]string, len(result))
+	for i, response := range result {
+		indices[i] = response.Index
+	}
+	return indices
+}
+
+// Reports failure to list indices, which is not fatal as the index pattern can still be searched. Authentication
+// failures are reported as errors, since they point to a misconfiguration rather than an unsupported endpoint.
+func reportCatIndicesError(err error) {
+	if elastic.IsUnauthorized(err) || elastic.IsForbidden(err) {
+		Error.Println("Not authorized to list indices, check credentials and headers. Using pattern instead.", err)
+		return
+	}
+	Info.Println("Could not fetch available indices. Using pattern instead.", err)
+}
+
+// Searches each of the indices matched by the index pattern separately, running at most tail.concurrency
+// searches in parallel, then merges the per-index results by timestamp. Only used in list mode since
+// follow mode depends on a single ordered result.
+func (tail *Tail) concurrentSearch(initialEntries int) (*elastic.SearchResult, error) {
+	indices := tail.resolveIndices()
+	if len(indices) < 2 {
+		return tail.initialSearch(initialEntries)
+	}
+	Info.Printf("Searching %d indices with concurrency %d", len(indices), tail.concurrency)
+
+	results := make([]*elastic.SearchResult, len(indices))
+	errs := make([]error, len(indices))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < tail.concurrency && w < len(indices); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = tail.executeSearch([]string{indices[i]}, tail.buildInitialSearchRequest(initialEntries))
+			}
+		}()
+	}
+	for i := range indices {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var totalHits int64
+	hits := make([][]*elastic.SearchHit, len(indices))
+	for i, result := range results {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if result.Hits != nil {
+			hits[i] = result.Hits.Hits
+		}
+		totalHits += result.TotalHits()
+	}
+	return newSearchResult(mergeHits(hits, tail.order, initialEntries), totalHits), nil
+}
+
+// K-way merge of hit lists that are each already sorted by timestamp (the first sort value) in given order.
+// Returns at most limit hits.
+func mergeHits(lists [][]*elastic.SearchHit, ascending bool, limit int) []*elastic.SearchHit {
+	merged := make([]*elastic.SearchHit, 0, limit)
+	heads := make([]int, len(lists))
+	for len(merged) < limit {
+		next := -1
+		for i, list := range lists {
+			if heads[i] >= len(list) {
+				continue
+			}
+			if next == -1 || isHitBefore(list[heads[i]], lists[next][heads[next]], ascending) {
+				next = i
+			}
+		}
+		if next == -1 {
+			break
+		}
+		merged = append(merged, lists[next][heads[next]])
+		heads[next]++
+	}
+	return merged
+}
+
+func isHitBefore(hit, other *elastic.SearchHit, ascending bool) bool {
+	value, otherValue := hitSortValue(hit), hitSortValue(other)
+	if ascending {
+		return value < otherValue
+	}
+	return value > otherValue
+}
+
+// Returns the first sort value of the hit (epoch millis when sorted by timestamp)
+func hitSortValue(hit *elastic.SearchHit) float64 {
+	if len(hit.Sort) == 0 {
+		return 0
+	}
+	value, _ := hit.Sort[0].(float64)
+	return value
+}
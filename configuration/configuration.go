@@ -8,10 +8,14 @@ package configuration
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"runtime"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/urfave/cli"
 )
@@ -23,42 +27,161 @@ var (
 )
 
 type SearchTarget struct {
-	Url          string
-	TunnelUrl    string `json:"-"`
-	IndexPattern string
-	Cert         string
-	Key          string
-	ExtraHeaders []string
+	Url           string
+	TunnelUrl     string `json:"-"`
+	IndexPattern  string
+	Cert          string
+	Key           string
+	TLSMinVersion string
+	CertData      string `json:"-"`
+	KeyData       string `json:"-"`
+	ExtraHeaders  []string
+	ProxyPath     string
+	ProxyMethod   string
 }
 
 type QueryDefinition struct {
 	Terms          []string
 	Format         string
 	TimestampField string
-	AfterDateTime  string `json:"-"`
-	BeforeDateTime string `json:"-"`
+	AfterDateTime  string        `json:"-"`
+	Since          time.Duration `json:"-"`
+	BeforeDateTime string        `json:"-"`
+	TraceId        string        `json:"-"`
+	TraceField     string        `json:"-"`
+	K8sNamespace   string        `json:"-"`
+	K8sPod         string        `json:"-"`
+	K8sContainer   string        `json:"-"`
+	ExcludeTime    string        `json:"-"`
+	Sample         float64       `json:"-"`
+	MinScore       float64       `json:"-"`
+	Wildcards      []string      `json:"-"`
+	Fuzzies        []string      `json:"-"`
+	AnyTerms       []string      `json:"-"`
+	Phrases        []string      `json:"-"`
+	PhraseField    string        `json:"-"`
+	TermsFile      string        `json:"-"`
+	TermsFileAny   bool          `json:"-"`
+	ImportQuery    string        `json:"-"`
 }
 
 type Configuration struct {
-	SearchTarget    SearchTarget
-	QueryDefinition QueryDefinition
-	InitialEntries  int
-	Follow          bool `json:"-"`
-	Raw             bool `json:"-"`
-	User            string
-	Password        string
-	Verbose         bool `json:"-"`
-	MoreVerbose     bool `json:"-"`
-	TraceRequests   bool `json:"-"`
-	SSHTunnelParams string
-	SaveQuery       bool `json:"-"`
+	SearchTarget           SearchTarget
+	QueryDefinition        QueryDefinition
+	InitialEntries         int
+	BatchSize              int           `json:"-"`
+	OnTruncation           string        `json:"-"`
+	MaxDedupIDs            int           `json:"-"`
+	DedupIdField           string        `json:"-"`
+	TrackUpdates           bool          `json:"-"`
+	Nanos                  bool          `json:"-"`
+	ReplayDedupLines       int           `json:"-"`
+	Preset                 string        `json:"-"`
+	MissingPlaceholder     string        `json:"-"`
+	Uniq                   bool          `json:"-"`
+	UniqCount              bool          `json:"-"`
+	Concurrency            int           `json:"-"`
+	MaxResponseBytes       int64         `json:"-"`
+	DumpResponses          string        `json:"-"`
+	MaxIdleConns           int           `json:"-"`
+	MaxIdleConnsPerHost    int           `json:"-"`
+	IdleConnTimeout        time.Duration `json:"-"`
+	Follow                 bool          `json:"-"`
+	TailOnly               bool          `json:"-"`
+	AfterId                string        `json:"-"`
+	PollOnDemand           bool          `json:"-"`
+	WaitTimeout            time.Duration `json:"-"`
+	Heartbeat              time.Duration `json:"-"`
+	StatsInterval          time.Duration `json:"-"`
+	MaxIterations          int           `json:"-"`
+	Head                   bool          `json:"-"`
+	Sort                   string        `json:"-"`
+	Raw                    bool          `json:"-"`
+	Output                 string        `json:"-"`
+	OutputFile             string        `json:"-"`
+	Fields                 []string      `json:"-"`
+	OutputFieldsOrder      string        `json:"-"`
+	Unescape               []string      `json:"-"`
+	HighlightFields        []string      `json:"-"`
+	Template               string        `json:"-"`
+	TemplateFile           string        `json:"-"`
+	Watch                  bool          `json:"-"`
+	Interactive            bool          `json:"-"`
+	View                   bool          `json:"-"`
+	ViewLines              int           `json:"-"`
+	LineBuffered           bool          `json:"-"`
+	Smart                  bool          `json:"-"`
+	AutoFields             bool          `json:"-"`
+	UseFieldsAPI           bool          `json:"-"`
+	ShowIndex              bool          `json:"-"`
+	Color                  string        `json:"-"`
+	TimeLayout             string        `json:"-"`
+	Grep                   string        `json:"-"`
+	GrepInvert             string        `json:"-"`
+	User                   string
+	Password               string
+	PasswordFile           string `json:"-"`
+	PasswordStdin          bool   `json:"-"`
+	Verbose                bool   `json:"-"`
+	MoreVerbose            bool   `json:"-"`
+	TraceRequests          bool   `json:"-"`
+	SSHTunnelParams        string
+	SSHKey                 string
+	AuthCookieFile         string
+	Profile                string        `json:"-"`
+	Env                    string        `json:"-"`
+	SSHKeepAlive           time.Duration `json:"-"`
+	OnReconnect            string        `json:"-"`
+	SaveQuery              bool          `json:"-"`
+	Combine                string        `json:"-"`
+	Escape                 bool          `json:"-"`
+	Explain                bool          `json:"-"`
+	SavedSearch            string        `json:"-"`
+	RuntimeFields          []string      `json:"-"`
+	Renames                []string      `json:"-"`
+	Decode                 []string      `json:"-"`
+	Coerce                 []string      `json:"-"`
+	QueryParams            []string      `json:"-"`
+	IncludeFrozen          bool          `json:"-"`
+	Preference             string        `json:"-"`
+	AllowMissing           bool          `json:"-"`
+	RunAs                  string        `json:"-"`
+	OpaqueId               string        `json:"-"`
+	OpaqueIdPrefix         string        `json:"-"`
+	Check                  bool          `json:"-"`
+	QueriesFile            string        `json:"-"`
+	VersionCheck           bool          `json:"-"`
+	Export                 string        `json:"-"`
+	Unordered              bool          `json:"-"`
+	ReindexFormat          bool          `json:"-"`
+	Id                     string        `json:"-"`
+	Index                  string        `json:"-"`
+	UnanchoredIndexPattern bool          `json:"-"`
+	ListIndices            bool          `json:"-"`
+	IndexOffset            int           `json:"-"`
+	Histogram              string        `json:"-"`
+	Ends                   int           `json:"-"`
+	Count                  bool          `json:"-"`
+	TerminateAfter         int           `json:"-"`
+	FailOnEmpty            bool          `json:"-"`
+	FailOnFound            bool          `json:"-"`
+}
+
+const DefaultProxyPath = "/elasticsearch/{path}"
+const DefaultProxyMethod = "POST"
+
+// Built-in formats for common log shapes (field names as shipped by filebeat), selected with --preset
+var FormatPresets = map[string]string{
+	"syslog": "%@timestamp %host.hostname %process.name[%process.pid]: %message",
+	"nginx":  "%@timestamp %source.address \"%http.request.method %url.original\" %http.response.status_code %http.response.body.bytes \"%user_agent.original\"",
+	"k8s":    "%@timestamp %kubernetes.namespace/%kubernetes.pod.name/%kubernetes.container.name :: %message",
 }
 
 var confDir = ".elktail"
 var defaultConfFile = "default.json"
 
-//When changing this array, make sure to also make appropriate changes in CopyConfigRelevantSettingsTo
-var configRelevantFlags = []string{"url", "i", "t", "u", "ssh", "l"}
+// When changing this array, make sure to also make appropriate changes in CopyConfigRelevantSettingsTo
+var configRelevantFlags = []string{"url", "i", "t", "u", "ssh", "l", "proxy-path", "proxy-method", "ssh-key", "tls-min-version", "auth-cookie-file"}
 
 func userHomeDir() string {
 	if runtime.GOOS == "windows" {
@@ -80,15 +203,18 @@ func (c *Configuration) Copy() *Configuration {
 	return result
 }
 
-//When making change here make sure configRelevantFlags global var is also changed
+// When making change here make sure configRelevantFlags global var is also changed
 func (c *Configuration) CopyConfigRelevantSettingsTo(dest *Configuration) {
 	//copy config relevant configuration settings
 	dest.SearchTarget.TunnelUrl = c.SearchTarget.TunnelUrl
 	dest.SearchTarget.Url = c.SearchTarget.Url
 	dest.SearchTarget.ExtraHeaders = make([]string, len(c.SearchTarget.ExtraHeaders))
 	copy(dest.SearchTarget.ExtraHeaders, c.SearchTarget.ExtraHeaders)
+	dest.SearchTarget.ProxyPath = c.SearchTarget.ProxyPath
+	dest.SearchTarget.ProxyMethod = c.SearchTarget.ProxyMethod
 	dest.SearchTarget.Cert = c.SearchTarget.Cert
 	dest.SearchTarget.Key = c.SearchTarget.Key
+	dest.SearchTarget.TLSMinVersion = c.SearchTarget.TLSMinVersion
 	dest.SearchTarget.IndexPattern = c.SearchTarget.IndexPattern
 	dest.QueryDefinition.Format = c.QueryDefinition.Format
 	dest.QueryDefinition.Terms = make([]string, len(c.QueryDefinition.Terms))
@@ -97,22 +223,146 @@ func (c *Configuration) CopyConfigRelevantSettingsTo(dest *Configuration) {
 	dest.User = c.User
 	dest.Password = c.Password
 	dest.SSHTunnelParams = c.SSHTunnelParams
+	dest.SSHKey = c.SSHKey
+	dest.AuthCookieFile = c.AuthCookieFile
 }
 
 func (c *Configuration) CopyNonConfigRelevantSettingsTo(dest *Configuration) {
 	//copy non-config relevant settings
 	dest.QueryDefinition.TimestampField = c.QueryDefinition.TimestampField
 	dest.QueryDefinition.AfterDateTime = c.QueryDefinition.AfterDateTime
+	dest.QueryDefinition.Since = c.QueryDefinition.Since
+	dest.QueryDefinition.TraceId = c.QueryDefinition.TraceId
+	dest.QueryDefinition.TraceField = c.QueryDefinition.TraceField
+	dest.QueryDefinition.K8sNamespace = c.QueryDefinition.K8sNamespace
+	dest.QueryDefinition.K8sPod = c.QueryDefinition.K8sPod
+	dest.QueryDefinition.K8sContainer = c.QueryDefinition.K8sContainer
+	dest.QueryDefinition.ExcludeTime = c.QueryDefinition.ExcludeTime
+	dest.QueryDefinition.Sample = c.QueryDefinition.Sample
+	dest.QueryDefinition.MinScore = c.QueryDefinition.MinScore
+	dest.QueryDefinition.Wildcards = make([]string, len(c.QueryDefinition.Wildcards))
+	copy(dest.QueryDefinition.Wildcards, c.QueryDefinition.Wildcards)
+	dest.QueryDefinition.Fuzzies = make([]string, len(c.QueryDefinition.Fuzzies))
+	copy(dest.QueryDefinition.Fuzzies, c.QueryDefinition.Fuzzies)
+	dest.QueryDefinition.AnyTerms = make([]string, len(c.QueryDefinition.AnyTerms))
+	copy(dest.QueryDefinition.AnyTerms, c.QueryDefinition.AnyTerms)
+	dest.QueryDefinition.Phrases = make([]string, len(c.QueryDefinition.Phrases))
+	copy(dest.QueryDefinition.Phrases, c.QueryDefinition.Phrases)
+	dest.QueryDefinition.PhraseField = c.QueryDefinition.PhraseField
+	dest.QueryDefinition.TermsFile = c.QueryDefinition.TermsFile
+	dest.QueryDefinition.TermsFileAny = c.QueryDefinition.TermsFileAny
+	dest.QueryDefinition.ImportQuery = c.QueryDefinition.ImportQuery
 	dest.QueryDefinition.BeforeDateTime = c.QueryDefinition.BeforeDateTime
 	dest.Follow = c.Follow
+	dest.TailOnly = c.TailOnly
+	dest.AfterId = c.AfterId
+	dest.PollOnDemand = c.PollOnDemand
+	dest.WaitTimeout = c.WaitTimeout
+	dest.Heartbeat = c.Heartbeat
+	dest.StatsInterval = c.StatsInterval
+	dest.MaxIterations = c.MaxIterations
+	dest.Head = c.Head
+	dest.Sort = c.Sort
 	dest.Raw = c.Raw
+	dest.Output = c.Output
+	dest.OutputFile = c.OutputFile
+	dest.Template = c.Template
+	dest.TemplateFile = c.TemplateFile
+	dest.Watch = c.Watch
+	dest.Interactive = c.Interactive
+	dest.View = c.View
+	dest.ViewLines = c.ViewLines
+	dest.Fields = make([]string, len(c.Fields))
+	copy(dest.Fields, c.Fields)
+	dest.OutputFieldsOrder = c.OutputFieldsOrder
+	dest.Unescape = make([]string, len(c.Unescape))
+	copy(dest.Unescape, c.Unescape)
+	dest.HighlightFields = make([]string, len(c.HighlightFields))
+	copy(dest.HighlightFields, c.HighlightFields)
+	dest.LineBuffered = c.LineBuffered
+	dest.Smart = c.Smart
+	dest.AutoFields = c.AutoFields
+	dest.UseFieldsAPI = c.UseFieldsAPI
+	dest.ShowIndex = c.ShowIndex
+	dest.Color = c.Color
+	dest.TimeLayout = c.TimeLayout
+	dest.Grep = c.Grep
+	dest.GrepInvert = c.GrepInvert
 	dest.InitialEntries = c.InitialEntries
+	dest.BatchSize = c.BatchSize
+	dest.OnTruncation = c.OnTruncation
+	dest.MaxDedupIDs = c.MaxDedupIDs
+	dest.DedupIdField = c.DedupIdField
+	dest.TrackUpdates = c.TrackUpdates
+	dest.Nanos = c.Nanos
+	dest.ReplayDedupLines = c.ReplayDedupLines
+	dest.Preset = c.Preset
+	dest.MissingPlaceholder = c.MissingPlaceholder
+	dest.Uniq = c.Uniq
+	dest.UniqCount = c.UniqCount
+	dest.Concurrency = c.Concurrency
+	dest.MaxResponseBytes = c.MaxResponseBytes
+	dest.DumpResponses = c.DumpResponses
+	dest.MaxIdleConns = c.MaxIdleConns
+	dest.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	dest.IdleConnTimeout = c.IdleConnTimeout
 	dest.Verbose = c.Verbose
 	dest.MoreVerbose = c.MoreVerbose
 	dest.TraceRequests = c.TraceRequests
+	dest.SSHKeepAlive = c.SSHKeepAlive
+	dest.OnReconnect = c.OnReconnect
+	dest.Profile = c.Profile
+	dest.Env = c.Env
+	dest.PasswordFile = c.PasswordFile
+	dest.PasswordStdin = c.PasswordStdin
+	dest.Check = c.Check
+	dest.QueriesFile = c.QueriesFile
+	dest.VersionCheck = c.VersionCheck
+	dest.Export = c.Export
+	dest.Unordered = c.Unordered
+	dest.ReindexFormat = c.ReindexFormat
+	dest.Id = c.Id
+	dest.Index = c.Index
+	dest.UnanchoredIndexPattern = c.UnanchoredIndexPattern
+	dest.ListIndices = c.ListIndices
+	dest.IndexOffset = c.IndexOffset
+	dest.Histogram = c.Histogram
+	dest.Ends = c.Ends
+	dest.Count = c.Count
+	dest.TerminateAfter = c.TerminateAfter
+	dest.FailOnEmpty = c.FailOnEmpty
+	dest.FailOnFound = c.FailOnFound
+	dest.SearchTarget.CertData = c.SearchTarget.CertData
+	dest.SearchTarget.KeyData = c.SearchTarget.KeyData
+	dest.QueryParams = make([]string, len(c.QueryParams))
+	copy(dest.QueryParams, c.QueryParams)
+	dest.Preference = c.Preference
+	dest.AllowMissing = c.AllowMissing
+	dest.RunAs = c.RunAs
+	dest.OpaqueId = c.OpaqueId
+	dest.OpaqueIdPrefix = c.OpaqueIdPrefix
+	dest.IncludeFrozen = c.IncludeFrozen
+	dest.Combine = c.Combine
+	dest.Escape = c.Escape
+	dest.Explain = c.Explain
+	dest.SavedSearch = c.SavedSearch
+	dest.RuntimeFields = make([]string, len(c.RuntimeFields))
+	copy(dest.RuntimeFields, c.RuntimeFields)
+	dest.Renames = make([]string, len(c.Renames))
+	copy(dest.Renames, c.Renames)
+	dest.Decode = make([]string, len(c.Decode))
+	copy(dest.Decode, c.Decode)
+	dest.Coerce = make([]string, len(c.Coerce))
+	copy(dest.Coerce, c.Coerce)
 }
 
+// SaveDefault saves configuration to the file of the default profile
 func (c *Configuration) SaveDefault() {
+	c.SaveProfile("")
+}
+
+// SaveProfile saves configuration to the file of the given profile (default profile if name is empty)
+func (c *Configuration) SaveProfile(name string) {
 	confDirPath := userHomeDir() + string(os.PathSeparator) + confDir
 	if _, err := os.Stat(confDirPath); os.IsNotExist(err) {
 		//conf directory doesn't exist, let's create it
@@ -127,15 +377,33 @@ func (c *Configuration) SaveDefault() {
 		Error.Printf("Failed to marshall configuration to json: %s.\n", err)
 		return
 	}
-	confFile := confDirPath + string(os.PathSeparator) + defaultConfFile
-	err = ioutil.WriteFile(confFile, confJson, 0700)
+	confFile := confDirPath + string(os.PathSeparator) + profileFile(name)
+	err = WriteSecretFile(confFile, confJson)
 	if err != nil {
 		Error.Printf("Failed to save configuration to file %s, %s\n", confFile, err)
 		return
 	}
 }
 
+// WriteSecretFile writes the file (e.g. with credentials) readable only by the owner. Permissions of an existing
+// file are fixed too, as they are only applied to new files when writing.
+func WriteSecretFile(path string, data []byte) error {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
+}
+
+// LoadDefault loads configuration of the default profile
 func LoadDefault() (conf *Configuration, err error) {
+	return LoadProfile("")
+}
+
+// LoadProfile loads configuration of the given profile (default profile if name is empty)
+func LoadProfile(name string) (conf *Configuration, err error) {
+	if err := ValidateProfileName(name); err != nil {
+		return nil, err
+	}
 	confDirPath := userHomeDir() + string(os.PathSeparator) + confDir
 	if _, err := os.Stat(confDirPath); os.IsNotExist(err) {
 		//conf directory doesn't exist, let's create it
@@ -144,7 +412,7 @@ func LoadDefault() (conf *Configuration, err error) {
 			return nil, err
 		}
 	}
-	confFile := confDirPath + string(os.PathSeparator) + defaultConfFile
+	confFile := confDirPath + string(os.PathSeparator) + profileFile(name)
 	var config *Configuration
 	confBytes, err := ioutil.ReadFile(confFile)
 	if err != nil {
@@ -157,6 +425,54 @@ func LoadDefault() (conf *Configuration, err error) {
 	return config, nil
 }
 
+// ListProfiles returns names of the saved profiles (except the default one), sorted
+func ListProfiles() ([]string, error) {
+	files, err := ioutil.ReadDir(userHomeDir() + string(os.PathSeparator) + confDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".json")
+		if file.IsDir() || name == file.Name() || file.Name() == defaultConfFile || ValidateProfileName(name) != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Name of the configuration file of the profile
+func profileFile(name string) string {
+	if name == "" {
+		return defaultConfFile
+	}
+	return name + ".json"
+}
+
+// ValidateProfileName checks that profile name can be used as a configuration file name
+func ValidateProfileName(name string) error {
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") || name == "default" {
+		return fmt.Errorf("invalid profile name %s", name)
+	}
+	return nil
+}
+
+// FormatPreset returns the format of the named preset
+func FormatPreset(name string) (string, error) {
+	format, ok := FormatPresets[name]
+	if !ok {
+		names := make([]string, 0, len(FormatPresets))
+		for presetName := range FormatPresets {
+			names = append(names, presetName)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("unknown preset %s, available presets are %s", name, strings.Join(names, ", "))
+	}
+	return format, nil
+}
+
 func (config *Configuration) Flags() []cli.Flag {
 	cli.VersionFlag = cli.BoolFlag{
 		Name:  "print-version, V",
@@ -167,6 +483,20 @@ func (config *Configuration) Flags() []cli.Flag {
 	}
 
 	return []cli.Flag{
+		cli.StringFlag{
+			Name:        "profile",
+			Value:       "",
+			Usage:       "Named profile settings marked with (*) are saved to and loaded from, instead of the default ones",
+			EnvVar:      "ELKTAIL_PROFILE",
+			Destination: &config.Profile,
+		},
+		cli.StringFlag{
+			Name:        "env",
+			Value:       "",
+			Usage:       "Environment substituted for {env} placeholder in index pattern, e.g. app-{env}-*",
+			EnvVar:      "ELKTAIL_ENV",
+			Destination: &config.Env,
+		},
 		cli.StringFlag{
 			Name:        "url",
 			Value:       "http://127.0.0.1:9200",
@@ -179,6 +509,55 @@ func (config *Configuration) Flags() []cli.Flag {
 			Usage: "(*) Extra header passed to requests. Curl-like format.",
 			//Destination: &config.SearchTarget.ExtraHeaders,
 		},
+		cli.StringSliceFlag{
+			Name:  "query-param",
+			Usage: "Extra query parameter passed to search requests in key=value format, e.g. ignore_throttled=false",
+		},
+		cli.StringFlag{
+			Name:        "proxy-path",
+			Value:       DefaultProxyPath,
+			Usage:       "(*) Path search requests are sent to through the Kibana proxy, {path} is replaced with the elasticsearch endpoint (e.g. _msearch)",
+			Destination: &config.SearchTarget.ProxyPath,
+		},
+		cli.StringFlag{
+			Name:        "proxy-method",
+			Value:       DefaultProxyMethod,
+			Usage:       "(*) HTTP method used for search requests sent through the Kibana proxy",
+			Destination: &config.SearchTarget.ProxyMethod,
+		},
+		cli.BoolFlag{
+			Name:        "include-frozen",
+			Usage:       "Also search frozen (throttled) indices, e.g. searchable snapshots, which may be slow",
+			Destination: &config.IncludeFrozen,
+		},
+		cli.StringFlag{
+			Name:        "run-as",
+			Value:       "",
+			Usage:       "Run requests as the given user (elasticsearch security run-as), applying that user's document level security",
+			Destination: &config.RunAs,
+		},
+		cli.StringFlag{
+			Name:        "opaque-id",
+			Usage:       "Tag requests with a random X-Opaque-Id header for correlation in server logs: session (same id for all requests) or request (new id per request)",
+			Destination: &config.OpaqueId,
+		},
+		cli.StringFlag{
+			Name:        "opaque-id-prefix",
+			Value:       "elktail-",
+			Usage:       "Prefix of X-Opaque-Id header values generated with --opaque-id",
+			Destination: &config.OpaqueIdPrefix,
+		},
+		cli.StringFlag{
+			Name:        "preference",
+			Value:       "",
+			Usage:       "Search preference (e.g. _local or a custom session string) used to route searches to the same shard copies",
+			Destination: &config.Preference,
+		},
+		cli.BoolFlag{
+			Name:        "allow-missing",
+			Usage:       "Don't fail when the index pattern matches no existing index (e.g. before the day's index is created). In follow mode, keep polling until the index appears",
+			Destination: &config.AllowMissing,
+		},
 		cli.StringFlag{
 			Name:        "cert",
 			Value:       "",
@@ -191,28 +570,223 @@ func (config *Configuration) Flags() []cli.Flag {
 			Usage:       "(*) key to use when accessing via TLS",
 			Destination: &config.SearchTarget.Key,
 		},
+		cli.StringFlag{
+			Name:        "tls-min-version",
+			Usage:       "(*) Minimum TLS version accepted when accessing via TLS: 1.2 or 1.3 (Go default if not set)",
+			Destination: &config.SearchTarget.TLSMinVersion,
+		},
+		cli.StringFlag{
+			Name:        "cert-data",
+			Value:       "",
+			Usage:       "PEM encoded certificate to use when accessing via TLS (alternative to --cert)",
+			EnvVar:      "ELKTAIL_CERT_DATA",
+			Destination: &config.SearchTarget.CertData,
+		},
+		cli.StringFlag{
+			Name:        "key-data",
+			Value:       "",
+			Usage:       "PEM encoded key to use when accessing via TLS (alternative to --key)",
+			EnvVar:      "ELKTAIL_KEY_DATA",
+			Destination: &config.SearchTarget.KeyData,
+		},
 		cli.BoolFlag{
 			Name:        "r,raw",
 			Usage:       "Output raw",
 			Destination: &config.Raw,
 		},
+		cli.StringFlag{
+			Name:        "output",
+			Value:       "text",
+			Usage:       "Output mode: text (rendered with format) or ndjson (flattened JSON object per entry with keys in stable order)",
+			Destination: &config.Output,
+		},
+		cli.StringFlag{
+			Name:        "output-file",
+			Usage:       "Also write the source of each printed entry as a JSON line to the file, e.g. to keep raw entries while watching formatted ones",
+			Destination: &config.OutputFile,
+		},
+		cli.StringFlag{
+			Name:        "template",
+			Value:       "",
+			Usage:       "Go template entries are rendered with instead of format, e.g. '{{.message}}' or '{{field . \"log.level\"}}'",
+			Destination: &config.Template,
+		},
+		cli.StringFlag{
+			Name:        "template-file",
+			Value:       "",
+			Usage:       "File with Go template entries are rendered with instead of format",
+			Destination: &config.TemplateFile,
+		},
+		cli.BoolFlag{
+			Name:        "watch",
+			Usage:       "Reload --template-file when it changes",
+			Destination: &config.Watch,
+		},
+		cli.BoolFlag{
+			Name:        "interactive",
+			Usage:       "Pick the fields to show from the fields of the latest entry, then start tailing",
+			Destination: &config.Interactive,
+		},
+		cli.BoolFlag{
+			Name:        "view",
+			Usage:       "Show output in a rolling view of the last lines, narrowed down live by the filter typed",
+			Destination: &config.View,
+		},
+		cli.IntFlag{
+			Name:        "view-lines",
+			Value:       1000,
+			Usage:       "Number of last lines kept in memory by --view",
+			Destination: &config.ViewLines,
+		},
+		cli.StringSliceFlag{
+			Name:  "fields",
+			Usage: "Fields included in ndjson output (comma separated or repeated), all fields if not given",
+		},
+		cli.StringFlag{
+			Name:        "output-fields-order",
+			Value:       "fields",
+			Usage:       "Order of keys in ndjson output: fields (in the order --fields are listed in) or sorted (alphabetically). Keys are sorted if --fields is not given",
+			Destination: &config.OutputFieldsOrder,
+		},
+		cli.BoolFlag{
+			Name:        "line-buffered",
+			Usage:       "Flush output after every line instead of after every batch of results (e.g. when piping to an interactive consumer)",
+			Destination: &config.LineBuffered,
+		},
+		cli.BoolFlag{
+			Name:        "smart",
+			Usage:       "Print the message field prefixed by timestamp if the entry has one, otherwise print raw entry",
+			Destination: &config.Smart,
+		},
+		cli.BoolFlag{
+			Name:        "auto-fields",
+			Usage:       "Fetch only the fields referenced in format instead of the whole entry",
+			Destination: &config.AutoFields,
+		},
+		cli.BoolFlag{
+			Name:        "use-fields-api",
+			Usage:       "Read entries from the fields API instead of _source, so values are formatted according to the mapping",
+			Destination: &config.UseFieldsAPI,
+		},
+		cli.BoolFlag{
+			Name:        "show-index",
+			Usage:       "Prefix each entry with the name of the index it came from",
+			Destination: &config.ShowIndex,
+		},
+		cli.StringSliceFlag{
+			Name:  "unescape",
+			Usage: "Render line breaks (also escaped \\n) of the field across indented lines, e.g. for stack traces (text output only)",
+		},
+		cli.StringSliceFlag{
+			Name:  "highlight-field",
+			Usage: "Print fragments of the field highlighted by elasticsearch, with the terms matched by the query marked, instead of the whole field (text output only)",
+		},
+		cli.StringFlag{
+			Name:        "grep",
+			Value:       "",
+			Usage:       "Only print entries whose rendered output matches the given regular expression",
+			Destination: &config.Grep,
+		},
+		cli.StringFlag{
+			Name:        "grep-v",
+			Value:       "",
+			Usage:       "Do not print entries whose rendered output matches the given regular expression",
+			Destination: &config.GrepInvert,
+		},
 		cli.BoolFlag{
 			Name:        "f,follow",
 			Usage:       "Follow result, like tail -f",
 			Destination: &config.Follow,
 		},
+		cli.BoolFlag{
+			Name:        "tail-only",
+			Usage:       "In follow mode, skip the initial entries and only show entries arriving after start, like tail -n0 -f",
+			Destination: &config.TailOnly,
+		},
+		cli.StringFlag{
+			Name:        "after-id",
+			Usage:       "Follow entries arriving after the document with the given _id, skipping the initial entries (implies -f)",
+			Destination: &config.AfterId,
+		},
+		cli.BoolFlag{
+			Name:        "poll-on-demand",
+			Usage:       "In follow mode, fetch new entries only when Enter is pressed instead of polling continuously",
+			Destination: &config.PollOnDemand,
+		},
+		cli.DurationFlag{
+			Name:        "wait-timeout",
+			Usage:       "In follow mode, exit if no entry matching the query arrives within the timeout, e.g. 10m (waits forever by default)",
+			Destination: &config.WaitTimeout,
+		},
+		cli.DurationFlag{
+			Name:        "heartbeat",
+			Usage:       "In follow mode, print a status line to stderr when no new entries arrived for the interval, e.g. 5m",
+			Destination: &config.Heartbeat,
+		},
+		cli.DurationFlag{
+			Name:        "stats-interval",
+			Usage:       "In follow mode, print throughput stats (entries/s, total entries, poll delay, bytes processed) to stderr at the interval, e.g. 10s",
+			Destination: &config.StatsInterval,
+		},
+		cli.IntFlag{
+			Name:        "max-iterations",
+			Usage:       "In follow mode, stop after the given number of polls (for tests)",
+			Hidden:      true,
+			Destination: &config.MaxIterations,
+		},
+		cli.BoolFlag{
+			Name:        "head",
+			Usage:       "List the oldest entries (up to -n) instead of the newest, like head. Implies list-only mode",
+			Destination: &config.Head,
+		},
+		cli.StringFlag{
+			Name:        "sort",
+			Usage:       "In list-only mode, sort entries by the field instead of timestamp, in field[:asc|desc[:type]] format, e.g. duration:desc to list the slowest requests (descending by default, type of the field in indices it's not mapped in is long by default)",
+			Destination: &config.Sort,
+		},
 		cli.StringFlag{
 			Name:        "l,format",
 			Value:       "%@timestamp :: %message",
 			Usage:       "(*) Message format for the entries - field names are referenced using % sign, for example '%@timestamp %message'",
 			Destination: &config.QueryDefinition.Format,
 		},
+		cli.StringFlag{
+			Name:        "preset",
+			Value:       "",
+			Usage:       "Use a built-in format for common log shapes: syslog, nginx or k8s (--format overrides it)",
+			Destination: &config.Preset,
+		},
+		cli.StringFlag{
+			Name:        "missing-field-placeholder",
+			Value:       "",
+			Usage:       "Rendered in place of format fields the entry doesn't have, e.g. - to tell them apart from empty values",
+			Destination: &config.MissingPlaceholder,
+		},
+		cli.StringSliceFlag{
+			Name:  "runtime-field",
+			Usage: "Runtime field computed at query time in name:type:script format (script is Painless), usable in format as %name",
+		},
 		cli.StringFlag{
 			Name:        "i,index-pattern",
 			Value:       "filebeat-*",
 			Usage:       "(*) Index pattern - elktail will attempt to tail only the latest of logstash's indexes matched by the pattern",
 			Destination: &config.SearchTarget.IndexPattern,
 		},
+		cli.BoolFlag{
+			Name:        "unanchored-index-pattern",
+			Usage:       "Match index pattern anywhere in index names, as older versions did, instead of at their start",
+			Destination: &config.UnanchoredIndexPattern,
+		},
+		cli.BoolFlag{
+			Name:        "list-indices",
+			Usage:       "Print the indices matched by the index pattern (and date range) and exit, as JSON with --output ndjson, with document counts and sizes with -v",
+			Destination: &config.ListIndices,
+		},
+		cli.IntFlag{
+			Name:        "index-offset",
+			Usage:       "Search the index the given number of generations before the latest one matched by the index pattern, e.g. 1 for the previous rollover index",
+			Destination: &config.IndexOffset,
+		},
 		cli.StringFlag{
 			Name:        "t,timestamp-field",
 			Value:       "@timestamp",
@@ -225,35 +799,357 @@ func (config *Configuration) Flags() []cli.Flag {
 			Usage:       "Number of entries fetched initially",
 			Destination: &config.InitialEntries,
 		},
+		cli.IntFlag{
+			Name:        "batch-size",
+			Value:       9000,
+			Usage:       "Maximum number of entries fetched by each poll in follow mode",
+			Destination: &config.BatchSize,
+		},
+		cli.StringFlag{
+			Name:        "on-truncation",
+			Value:       "warn",
+			Usage:       "What to do when a poll in follow mode returns --batch-size entries, meaning some were probably skipped: warn, fail or ignore (only logged with -v)",
+			Destination: &config.OnTruncation,
+		},
+		cli.IntFlag{
+			Name:        "max-dedup-ids",
+			Value:       10000,
+			Usage:       "Maximum number of recent entry IDs excluded from follow queries to avoid printing duplicates",
+			Destination: &config.MaxDedupIDs,
+		},
+		cli.StringFlag{
+			Name:        "dedup-id-field",
+			Value:       "",
+			Usage:       "Field (e.g. a stable business key) identifying entries when removing duplicates in follow mode, instead of _id",
+			Destination: &config.DedupIdField,
+		},
+		cli.BoolFlag{
+			Name:        "track-updates",
+			Usage:       "In follow mode, print documents again when they are updated in place (tracked by _seq_no and _primary_term instead of only _id)",
+			Destination: &config.TrackUpdates,
+		},
+		cli.BoolFlag{
+			Name:        "nanos",
+			Usage:       "Query timestamps with nanosecond precision in follow mode (detected if the timestamp field is date_nanos and its mapping can be read)",
+			Destination: &config.Nanos,
+		},
+		cli.IntFlag{
+			Name:        "replay-dedup-lines",
+			Usage:       "Remember the given number of last printed lines and suppress them if printed again, e.g. when the search is reissued after reconnecting",
+			Destination: &config.ReplayDedupLines,
+		},
+		cli.BoolFlag{
+			Name:        "uniq",
+			Usage:       "Suppress lines identical to the previously printed line, like uniq",
+			Destination: &config.Uniq,
+		},
+		cli.BoolFlag{
+			Name:        "uniq-count",
+			Usage:       "Like --uniq, but print '... (repeated N times)' when a run of identical lines ends",
+			Destination: &config.UniqCount,
+		},
+		cli.IntFlag{
+			Name:        "concurrency",
+			Value:       1,
+			Usage:       "In list-only mode, search each index matched by the pattern separately with this many searches in parallel",
+			Destination: &config.Concurrency,
+		},
+		cli.Int64Flag{
+			Name:        "max-response-bytes",
+			Value:       0,
+			Usage:       "Fail with an error when a response from ElasticSearch is larger than this many bytes (0 means no limit)",
+			Destination: &config.MaxResponseBytes,
+		},
+		cli.StringFlag{
+			Name:        "dump-responses",
+			Usage:       "Write the raw body of each ElasticSearch response to its own timestamped file in the directory, for debugging",
+			Destination: &config.DumpResponses,
+		},
+		cli.IntFlag{
+			Name:        "max-idle-conns",
+			Value:       10,
+			Usage:       "Maximum number of idle (keep-alive) connections kept open",
+			Destination: &config.MaxIdleConns,
+		},
+		cli.IntFlag{
+			Name:        "max-idle-conns-per-host",
+			Value:       10,
+			Usage:       "Maximum number of idle (keep-alive) connections kept open to a single host",
+			Destination: &config.MaxIdleConnsPerHost,
+		},
+		cli.DurationFlag{
+			Name:        "idle-conn-timeout",
+			Value:       90 * time.Second,
+			Usage:       "How long idle (keep-alive) connections are kept open",
+			Destination: &config.IdleConnTimeout,
+		},
 		cli.StringFlag{
 			Name:        "a,after",
 			Value:       "",
 			Usage:       "List results after specified date (example: -a \"2016-06-17T15:00\")",
 			Destination: &config.QueryDefinition.AfterDateTime,
 		},
+		cli.DurationFlag{
+			Name:        "since",
+			Usage:       "List results from the given duration ago until now (example: --since 15m or --since 2h30m). Can not be combined with -a",
+			Destination: &config.QueryDefinition.Since,
+		},
 		cli.StringFlag{
 			Name:        "b,before",
 			Value:       "",
 			Usage:       "List results before specified date (example: -b \"2016-06-17T15:00\")",
 			Destination: &config.QueryDefinition.BeforeDateTime,
 		},
+		cli.StringFlag{
+			Name:        "trace-id",
+			Value:       "",
+			Usage:       "Follow entries with the given trace/correlation id (implies -f)",
+			Destination: &config.QueryDefinition.TraceId,
+		},
+		cli.StringFlag{
+			Name:        "trace-field",
+			Value:       "trace.id",
+			Usage:       "Field holding trace/correlation id used by --trace-id",
+			Destination: &config.QueryDefinition.TraceField,
+		},
+		cli.StringFlag{
+			Name:        "k8s-namespace",
+			Usage:       "Only entries from the given Kubernetes namespace (kubernetes.namespace field)",
+			Destination: &config.QueryDefinition.K8sNamespace,
+		},
+		cli.StringFlag{
+			Name:        "k8s-pod",
+			Usage:       "Only entries from the given Kubernetes pod (kubernetes.pod.name field)",
+			Destination: &config.QueryDefinition.K8sPod,
+		},
+		cli.StringFlag{
+			Name:        "k8s-container",
+			Usage:       "Only entries from the given Kubernetes container (kubernetes.container.name field)",
+			Destination: &config.QueryDefinition.K8sContainer,
+		},
+		cli.StringFlag{
+			Name:        "exclude-time",
+			Usage:       "Exclude entries logged within the given time of day window in UTC every day, in HH:MM-HH:MM format (e.g. 02:00-03:00 for a nightly job, or 22:00-02:00 across midnight)",
+			Destination: &config.QueryDefinition.ExcludeTime,
+		},
+		cli.Float64Flag{
+			Name:        "sample",
+			Usage:       "Return only approximately the given percentage (0-100) of matching entries, randomly sampled at query time",
+			Destination: &config.QueryDefinition.Sample,
+		},
+		cli.Float64Flag{
+			Name:        "min-score",
+			Usage:       "Only return entries whose relevance score for the query terms is at least the given value",
+			Destination: &config.QueryDefinition.MinScore,
+		},
+		cli.StringSliceFlag{
+			Name:  "wildcard",
+			Usage: "Only entries whose field matches the wildcard pattern, in field:value format, e.g. host:web-*",
+		},
+		cli.StringSliceFlag{
+			Name:  "phrase",
+			Usage: "Only entries whose --phrase-field contains the exact phrase (may be repeated), without query string quoting, e.g. --phrase 'connection reset by peer'",
+		},
+		cli.StringFlag{
+			Name:        "phrase-field",
+			Value:       "message",
+			Usage:       "Field searched for --phrase",
+			Destination: &config.QueryDefinition.PhraseField,
+		},
+		cli.StringSliceFlag{
+			Name:  "any",
+			Usage: "Only entries matching at least one of the query strings given with --any (may be repeated), e.g. --any timeout --any refused",
+		},
+		cli.StringFlag{
+			Name:        "terms-file",
+			Usage:       "Read query terms from the file, one per line (# starts a comment line). Entries have to match all of them",
+			Destination: &config.QueryDefinition.TermsFile,
+		},
+		cli.BoolFlag{
+			Name:        "terms-file-any",
+			Usage:       "Entries have to match at least one of the --terms-file terms (like --any) instead of all of them",
+			Destination: &config.QueryDefinition.TermsFileAny,
+		},
+		cli.StringFlag{
+			Name:        "queries-file",
+			Usage:       "Tail several named queries from the JSON file side by side, merged by timestamp and labeled with the query name",
+			Destination: &config.QueriesFile,
+		},
+		cli.StringSliceFlag{
+			Name:  "fuzzy",
+			Usage: "Only entries whose field fuzzily matches the value, in field:value~fuzziness format (fuzziness is AUTO if omitted)",
+		},
+		cli.StringSliceFlag{
+			Name:  "rename",
+			Usage: "Rename field in from=to format before output, e.g. log.level=severity to use it as %severity",
+		},
+		cli.StringSliceFlag{
+			Name:  "decode",
+			Usage: "Decode field before output, in field:encoding format where encoding is base64 or gzip (base64 encoded gzip), e.g. payload:gzip",
+		},
+		cli.StringSliceFlag{
+			Name:  "coerce",
+			Usage: "Render field as another type, in field:type format where type is int, float, bool or string, e.g. count:int to print 5.0 as 5",
+		},
+		cli.StringFlag{
+			Name:        "color",
+			Value:       "auto",
+			Usage:       "Color output lines by log level: auto (only when writing to a terminal), always or never",
+			Destination: &config.Color,
+		},
+		cli.StringFlag{
+			Name: "time-layout",
+			Usage: "Render timestamps in local time using a preset (kitchen, stamp, rfc3339, rfc3339nano, unix) or " +
+				"Go reference time layout, e.g. 15:04:05",
+			Destination: &config.TimeLayout,
+		},
 		cli.BoolFlag{
 			Name:        "s",
-			Usage:       "Save query terms - next invocation of elktail (without parameters) will use saved query terms. Any additional terms specified will be applied with AND operator to saved terms",
+			Usage:       "Save query terms - next invocation of elktail (without parameters) will use saved query terms. Any additional terms specified will be applied with the --combine operator to saved terms",
 			Destination: &config.SaveQuery,
 		},
+		cli.StringFlag{
+			Name:        "combine",
+			Value:       "and",
+			Usage:       "Operator applied between saved query terms and additional terms given: and, or",
+			Destination: &config.Combine,
+		},
+		cli.BoolFlag{
+			Name:        "escape",
+			Usage:       "Escape query string reserved characters (e.g. / : [ ]) in query terms so they are searched literally",
+			Destination: &config.Escape,
+		},
+		cli.StringFlag{
+			Name:        "saved-search",
+			Value:       "",
+			Usage:       "Id of Kibana saved search to take index pattern and query from. Query terms given are applied with AND operator",
+			Destination: &config.SavedSearch,
+		},
+		cli.StringFlag{
+			Name:        "import-query",
+			Usage:       "Take query and filters from the saved query exported from Kibana (saved objects export file). Query terms given are applied with AND operator",
+			Destination: &config.QueryDefinition.ImportQuery,
+		},
+		cli.BoolFlag{
+			Name:        "explain",
+			Usage:       "Print explanation of why the entry matched the query under each of the first 5 entries",
+			Destination: &config.Explain,
+		},
 		cli.StringFlag{
 			Name:        "u",
 			Value:       "",
 			Usage:       "(*) Username and password for authentication. curl-like format (separated by colon)",
 			Destination: &config.User,
 		},
+		cli.StringFlag{
+			Name:        "password-file",
+			Value:       "",
+			Usage:       "Read the password for authentication from the given file (trailing newline is trimmed)",
+			Destination: &config.PasswordFile,
+		},
+		cli.BoolFlag{
+			Name:        "password-stdin",
+			Usage:       "Read the password for authentication from stdin (trailing newline is trimmed)",
+			Destination: &config.PasswordStdin,
+		},
 		cli.StringFlag{
 			Name:        "ssh,ssh-tunnel",
 			Value:       "",
 			Usage:       "(*) Use ssh tunnel to connect. Format for the argument is [localport:][user@]sshhost.tld[:sshport]",
 			Destination: &config.SSHTunnelParams,
 		},
+		cli.StringFlag{
+			Name:        "ssh-key",
+			Value:       "",
+			Usage:       "(*) Private key file used to authenticate to the ssh tunnel host (in addition to ssh agent)",
+			Destination: &config.SSHKey,
+		},
+		cli.StringFlag{
+			Name:        "auth-cookie-file",
+			Value:       "",
+			Usage:       "(*) File the Kibana auth cookie is kept in (default ~/.elktail/auth.cookie)",
+			Destination: &config.AuthCookieFile,
+		},
+		cli.DurationFlag{
+			Name:        "ssh-keepalive",
+			Value:       30 * time.Second,
+			Usage:       "Interval of SSH keepalive requests used to detect a dead tunnel and reconnect it (0 disables them)",
+			Destination: &config.SSHKeepAlive,
+		},
+		cli.StringFlag{
+			Name:        "on-reconnect",
+			Usage:       "Shell command run whenever the ssh tunnel is re-established, with ELKTAIL_RECONNECT_SERVER, ELKTAIL_RECONNECT_ATTEMPTS and ELKTAIL_RECONNECT_ERROR environment variables set",
+			Destination: &config.OnReconnect,
+		},
+		cli.BoolFlag{
+			Name:        "fail-on-empty",
+			Usage:       "In list-only mode, exit with status 1 if no entries were printed",
+			Destination: &config.FailOnEmpty,
+		},
+		cli.BoolFlag{
+			Name:        "fail-on-found",
+			Usage:       "In list-only mode, exit with status 1 if any entries were printed (e.g. alert if errors are found)",
+			Destination: &config.FailOnFound,
+		},
+		cli.StringFlag{
+			Name:        "histogram",
+			Value:       "",
+			Usage:       "Print a histogram of entry counts per time interval (e.g. 1m, 1h) instead of entries. Implies list-only mode",
+			Destination: &config.Histogram,
+		},
+		cli.IntFlag{
+			Name:        "ends",
+			Usage:       "Print the first and the last given number of entries, with the number of entries omitted between them. Implies list-only mode",
+			Destination: &config.Ends,
+		},
+		cli.BoolFlag{
+			Name:        "count",
+			Usage:       "Print the number of entries matching the query (and date range) instead of the entries. Implies list-only mode",
+			Destination: &config.Count,
+		},
+		cli.IntFlag{
+			Name:        "terminate-after",
+			Usage:       "Stop collecting entries on each shard after the given number of them, for fast existence checks and samples of huge indices (e.g. with --count)",
+			Destination: &config.TerminateAfter,
+		},
+		cli.StringFlag{
+			Name:        "export",
+			Value:       "",
+			Usage:       "Export all entries matching the query (and date range) to the given file in elasticsearch _bulk format and exit",
+			Destination: &config.Export,
+		},
+		cli.BoolFlag{
+			Name:        "unordered",
+			Usage:       "Export entries in index order (sorted by _doc) instead of by timestamp, which is much faster for big exports",
+			Destination: &config.Unordered,
+		},
+		cli.BoolFlag{
+			Name:        "reindex-format",
+			Usage:       "Export each document as a plain _source line without _bulk action lines (restricted to --fields if given), for pipelines supplying their own index",
+			Destination: &config.ReindexFormat,
+		},
+		cli.StringFlag{
+			Name:        "id",
+			Value:       "",
+			Usage:       "Fetch and print the single document with the given _id (from --index) and exit",
+			Destination: &config.Id,
+		},
+		cli.StringFlag{
+			Name:        "index",
+			Value:       "",
+			Usage:       "Index of the document fetched with --id",
+			Destination: &config.Index,
+		},
+		cli.BoolFlag{
+			Name:        "version-check",
+			Usage:       "Warn if the server version is outside of the range elktail is known to work with",
+			Destination: &config.VersionCheck,
+		},
+		cli.BoolFlag{
+			Name:        "check",
+			Usage:       "Test the connection (DNS, TLS, SSH tunnel, authentication and search), report each step and exit",
+			Destination: &config.Check,
+		},
 		cli.BoolFlag{
 			Name:        "v1",
 			Usage:       "Enable verbose output (for debugging)",
@@ -278,9 +1174,10 @@ func (c *Configuration) IsRaw() bool {
 	return c.Raw
 }
 
-//Elktail will work in list-only (no follow) mode if appropriate flag is set or if query has date-time filtering enabled
+// Elktail will work in list-only (no follow) mode if appropriate flag is set, if query has date-time filtering enabled,
+// if listing the oldest entries (head) or printing histogram
 func (c *Configuration) IsListOnly() bool {
-	return !c.Follow || c.QueryDefinition.IsDateTimeFiltered()
+	return !c.Follow || c.QueryDefinition.IsDateTimeFiltered() || c.Head || c.Histogram != "" || c.Ends > 0 || c.Count
 }
 
 func (q *QueryDefinition) IsDateTimeFiltered() bool {
@@ -0,0 +1,94 @@
+/* Copyright (C) 2022 Piers Harding
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license. See the LICENSE file for details.
+ */
+
+package configuration
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	tu "github.com/piersharding/elktail/testutils"
+)
+
+func TestProfileRoundTrip(t *testing.T) {
+	home, err := ioutil.TempDir("", "elktail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	t.Setenv("HOME", home)
+
+	staging := &Configuration{SSHTunnelParams: "ops@bastion.staging.example.com", SSHKey: "/keys/staging"}
+	staging.SearchTarget.Url = "http://es.staging:9200"
+	staging.SaveProfile("staging")
+	production := &Configuration{SSHTunnelParams: "9198:ops@bastion.example.com:2222", SSHKey: "/keys/production"}
+	production.SearchTarget.Url = "http://es.production:9200"
+	production.SaveDefault()
+
+	loaded, err := LoadProfile("staging")
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	merged := new(Configuration)
+	loaded.CopyConfigRelevantSettingsTo(merged)
+	tu.AssertEqualsString(t, "http://es.staging:9200", merged.SearchTarget.Url)
+	tu.AssertEqualsString(t, "ops@bastion.staging.example.com", merged.SSHTunnelParams)
+	tu.AssertEqualsString(t, "/keys/staging", merged.SSHKey)
+
+	loaded, err = LoadDefault()
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, "9198:ops@bastion.example.com:2222", loaded.SSHTunnelParams)
+	tu.AssertEqualsString(t, "/keys/production", loaded.SSHKey)
+
+	if _, err := LoadProfile("../default"); err == nil {
+		tu.Fail(t, "Expected error for profile name with path separator")
+	}
+
+	profiles, err := ListProfiles()
+	if err != nil || len(profiles) != 1 {
+		tu.Fail(t, "Expected only the staging profile to be listed")
+	}
+	tu.AssertEqualsString(t, "staging", profiles[0])
+}
+
+func TestWriteSecretFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "elktail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	os.Chmod(file.Name(), 0755)
+
+	if err := WriteSecretFile(file.Name(), []byte("secret")); err != nil {
+		tu.Fail(t, err.Error())
+	}
+	info, err := os.Stat(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		tu.Fail(t, "Expected 0600 permissions, got "+info.Mode().Perm().String())
+	}
+}
+
+func TestFormatPreset(t *testing.T) {
+	format, err := FormatPreset("k8s")
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, FormatPresets["k8s"], format)
+
+	_, err = FormatPreset("apache")
+	if err == nil {
+		tu.Fail(t, "Expected error for unknown preset")
+	}
+	tu.AssertEqualsString(t, "unknown preset apache, available presets are k8s, nginx, syslog", err.Error())
+}
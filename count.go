@@ -0,0 +1,33 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// PrintCount prints the number of entries matching the query (and date range) instead of the entries. With
+// --terminate-after, shards stop counting after that many entries, so the count is a lower bound, which is enough
+// to check whether anything matches.
+func (tail *Tail) PrintCount(out io.Writer) (int64, error) {
+	searchRequest := tail.newSearchRequest().
+		Query(tail.buildSearchQuery()).
+		Size(0).
+		TrackTotalHits(true)
+	result, err := tail.executeSearch(tail.indices, searchRequest)
+	if err != nil {
+		return 0, err
+	}
+	count := result.TotalHits()
+	if result.TerminatedEarly {
+		fmt.Fprintf(os.Stderr, "Counting terminated early after %d entries per shard, count is a lower bound.\n",
+			tail.terminateAfter)
+	}
+	_, err = fmt.Fprintln(out, count)
+	return count, err
+}
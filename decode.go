@@ -0,0 +1,82 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Encodings of fields decoded with --decode. JSON can't hold binary data, so gzip compressed values are expected
+// to be base64 encoded as well.
+const (
+	decodeBase64 = "base64"
+	decodeGzip   = "gzip"
+)
+
+type fieldDecoder struct {
+	field    string
+	encoding string
+}
+
+// ParseDecoders parses field decoders given in field:encoding format
+func ParseDecoders(definitions []string) ([]fieldDecoder, error) {
+	result := make([]fieldDecoder, 0, len(definitions))
+	for _, definition := range definitions {
+		field, encoding, err := parseFieldValue(definition)
+		if err != nil {
+			return nil, err
+		}
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding != decodeBase64 && encoding != decodeGzip {
+			return nil, fmt.Errorf("%s is not one of %s or %s", encoding, decodeBase64, decodeGzip)
+		}
+		result = append(result, fieldDecoder{field: strings.TrimSpace(field), encoding: encoding})
+	}
+	return result, nil
+}
+
+// Replaces values of the fields with their decoded content. Values that fail to decode are kept as they are.
+func decodeFields(entry map[string]interface{}, decoders []fieldDecoder) {
+	for _, decoder := range decoders {
+		parent, key, ok := lookupField(entry, decoder.field)
+		if !ok {
+			continue
+		}
+		value, ok := parent[key].(string)
+		if !ok {
+			continue
+		}
+		decoded, err := decodeValue(value, decoder.encoding)
+		if err != nil {
+			Trace.Printf("Failed decoding %s field as %s, keeping the raw value: %s", decoder.field, decoder.encoding, err)
+			continue
+		}
+		parent[key] = decoded
+	}
+}
+
+func decodeValue(value string, encoding string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return "", err
+	}
+	if encoding == decodeGzip {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		if data, err = ioutil.ReadAll(reader); err != nil {
+			return "", err
+		}
+	}
+	return string(data), nil
+}
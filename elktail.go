@@ -7,21 +7,27 @@ of the MIT license. See the LICENSE file for details.
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/olivere/elastic/v7"
 	configuration "github.com/piersharding/elktail/configuration"
 	"github.com/urfave/cli"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/terminal"
 	"golang.org/x/net/context"
 )
@@ -30,13 +36,74 @@ import (
 // Tail is a structure that holds data necessary to perform tailing.
 //
 type Tail struct {
-	client          *elastic.Client                //elastic search client that we'll use to contact EL
-	queryDefinition *configuration.QueryDefinition //structure containing query definition and formatting
-	indices         []string                       //indices to search through
-	lastTimeStamp   string                         //timestamp of the last result
-	lastIDs         []displayedEntry               //result IDs that we fetched in the last query, used to avoid duplicates when using tailing query time window
-	order           bool                           //search order - true = ascending (may be reversed in case date-after filtering)
-	raw             bool                           // Raw output
+	client           *elastic.Client                //elastic search client that we'll use to contact EL
+	queryDefinition  *configuration.QueryDefinition //structure containing query definition and formatting
+	indices          []string                       //indices to search through
+	lastTimeStamp    string                         //timestamp of the last result
+	lastIDs          []displayedEntry               //result IDs that we fetched in the last query, used to avoid duplicates when using tailing query time window
+	maxDedupIDs      int                            //maximum number of IDs kept in lastIDs
+	dedupCapWarned   bool                           //whether user was warned about IDs evicted from lastIDs
+	timestampWarned  bool                           //whether user was warned about entries without timestamp
+	dedupIdField     string                         //field identifying entries in lastIDs, _id if empty
+	trackUpdates     bool                           //in follow mode, print documents again when they are updated
+	displayedVersion map[string]documentVersion     //versions of the entries in lastIDs, only with --track-updates
+	order            bool                           //search order - true = ascending (may be reversed in case date-after filtering)
+	sortField        *elastic.FieldSort             //in list mode, sort by this field instead of timestamp (nil if not set)
+	raw              bool                           // Raw output
+	ndjson           bool                           //output entries as flattened JSON objects
+	template         *entryTemplate                 //Go template entries are rendered with instead of format
+	fields           []string                       //fields included in ndjson output (all if empty)
+	sortFields       bool                           //keys of ndjson output sorted instead of in the order of fields
+	smart            bool                           //print message field if present, raw output otherwise
+	showIndex        bool                           //prefix entries with their index
+	label            string                         //prefix of entries of a named query (--queries-file)
+	queryName        string                         //name of the named query, added to ndjson entries instead of label
+	color            bool                           //color output lines by log level
+	follow           bool                           //follow mode, new entries are polled after the initial ones
+	tailOnly         bool                           //in follow mode, skip initial entries and show only new ones
+	afterId          string                         //in follow mode, skip initial entries and show those after this document
+	pollOnDemand     bool                           //in follow mode, poll for new entries only when a line is read from stdin
+	waitTimeout      time.Duration                  //in follow mode, maximum wait for the first entry (forever if 0)
+	waitingReported  time.Time                      //when waiting for the first entry was last reported
+	heartbeat        time.Duration                  //in follow mode, print status to stderr when idle this long (never if 0)
+	maxIterations    int                            //in follow mode, stop after this many polls (never if 0)
+	statsInterval    time.Duration                  //in follow mode, print throughput stats to stderr this often (never if 0)
+	stats            *throughputStats               //throughput stats of the follow session, nil if not reported
+	concurrency      int                            //number of parallel per-index searches in list mode
+	batchSize        int                            //entries fetched by each poll in follow mode
+	onTruncation     string                         //what to do when a poll returns a full batch: warn, fail or ignore
+	escape           bool                           //escape query string reserved characters in query terms
+	preference       string                         //search preference, e.g. _local or a custom session string
+	allowMissing     bool                           //treat missing indices as empty instead of failing the search
+	grep             *regexp.Regexp                 //only entries with output matching this regexp are printed
+	grepInvert       *regexp.Regexp                 //entries with output matching this regexp are not printed
+	termQueries      []elastic.Query                //wildcard and fuzzy clauses ANDed with the query
+	printedEntries   int64                          //number of entries printed so far
+	output           *bufio.Writer                  //buffered output, flushed after each batch of results
+	sinks            []outputSink                   //additional outputs printed entries are written to
+	lineBuffered     bool                           //flush output after each line
+	explain          bool                           //print scoring explanation of why entries matched
+	explainedEntries int                            //number of entries explanation was printed for
+	runtimeMappings  elastic.RuntimeMappings        //runtime fields computed at query time
+	runtimeFields    []string                       //names of runtime fields
+	sourceFields     []string                       //source fields fetched (all if empty)
+	useFieldsAPI     bool                           //read entries from the fields API instead of _source
+	timeLayout       string                         //layout of displayed timestamps (as returned if empty)
+	missingField     string                         //rendered in place of format fields missing in the entry
+	printedLines     *lineHistory                   //last printed lines, suppressed if printed again (nil if disabled)
+	repeatedLines    *repeatedLines                 //consecutive identical lines, suppressed like uniq (nil if disabled)
+	renames          []fieldRename                  //fields renamed in entries before output
+	decoders         []fieldDecoder                 //fields decoded (base64, gzip) in entries before output
+	coercions        []fieldCoercion                //fields coerced to another type in entries before output
+	importedFilters  []elastic.Query                //filters of the saved query imported from Kibana
+	unescape         []string                       //fields whose line breaks are rendered across lines
+	unordered        bool                           //export entries sorted by _doc instead of timestamp
+	reindexFormat    bool                           //export plain _source lines instead of _bulk format
+	highlight        *elastic.Highlight             //highlighting of fields matched by the query (nil if disabled)
+	highlightFields  []string                       //fields highlighted with --highlight-fields
+	excludedWindow   *timeOfDayWindow               //recurring time of day entries are excluded in (nil if disabled)
+	terminateAfter   int                            //maximum number of entries collected by each shard (unlimited if 0)
+	nanos            bool                           //timestamps have nanosecond precision (date_nanos field)
 }
 
 type displayedEntry struct {
@@ -44,40 +111,97 @@ type displayedEntry struct {
 	id        string
 }
 
+// Version of a document, changes when the document is updated
+type documentVersion struct {
+	seqNo       int64
+	primaryTerm int64
+}
+
+// Timestamps are compared as times, as their strings differ in the length of fractional seconds (and trailing
+// zeros are trimmed). Timestamps in other formats are compared as strings.
 func (entry *displayedEntry) isBefore(timeStamp string) bool {
-	return entry.timeStamp < timeStamp
+	entryTime, entryErr := time.Parse(dateFormatFull, entry.timeStamp)
+	otherTime, otherErr := time.Parse(dateFormatFull, timeStamp)
+	if entryErr != nil || otherErr != nil {
+		return entry.timeStamp < timeStamp
+	}
+	return entryTime.Before(otherTime)
 }
 
 const dateFormatDMY = "2006-01-02"
 const dateFormatFull = "2006-01-02T15:04:05.999Z07:00"
+const dateFormatNanos = "2006-01-02T15:04:05.999999999Z07:00"
+const dateNanosType = "date_nanos"
 const tailingTimeWindow = 500
+const maxResultWindow = 10000
+const maxExplainedEntries = 5
+const (
+	truncationWarn   = "warn"
+	truncationFail   = "fail"
+	truncationIgnore = "ignore"
+)
+const smartMessageField = "message"
 
-// NewTail creates a new Tailer using configuration
-func NewTail(configuration *configuration.Configuration) *Tail {
-	tail := new(Tail)
+// Creates the HTTP client all requests to elasticsearch (or Kibana) go through, applying TLS configuration,
+// authentication, extra headers and the Kibana proxy
+func newKibanaHTTPClient(configuration *configuration.Configuration, url string) (*http.Client, error) {
+	tlsConfig, err := loadTLSConfig(configuration)
+	if err != nil {
+		return nil, fmt.Errorf("bad TLS configuration (certificate, key or minimum version): %s", err)
+	}
+	extraHeaders := map[string]string{}
 
-	var client *elastic.Client
-	var err error
-	var url = configuration.SearchTarget.Url
-	if !strings.HasPrefix(url, "http") {
-		url = "http://" + url
-		Trace.Printf("Adding http:// prefix to given url. Url: " + url)
+	for _, header := range configuration.SearchTarget.ExtraHeaders {
+		if header != "" {
+			tokenized := ExtractHeader(header)
+			extraHeaders[tokenized[0]] = tokenized[1]
+		}
 	}
 
-	if !Must(regexp.MatchString(".*:\\d+", url)) && Must(regexp.MatchString("http://[^/]+$", url)) {
-		url += ":9200"
-		Trace.Printf("No port was specified, adding default port 9200 to given url. Url: " + url)
+	queryParams, err := ParseQueryParams(configuration.QueryParams)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query parameter: %s", err)
+	}
+	if configuration.IncludeFrozen {
+		queryParams.Set("ignore_throttled", "false")
 	}
 
-	//if a tunnel is successfully created, we need to connect to tunnel url (which is localhost on tunnel port)
-	if configuration.SearchTarget.TunnelUrl != "" {
-		url = configuration.SearchTarget.TunnelUrl
+	proxyURL, err := ResolveProxyURL(configuration.SearchTarget.ProxyPath, "_msearch")
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy path: %s", err)
+	}
+	proxyMethod := configuration.SearchTarget.ProxyMethod
+	if proxyMethod == "" {
+		//configuration saved by older versions has no proxy settings
+		proxyMethod = defaultProxyMethod
+	}
+
+	opaqueId, err := newOpaqueIdGenerator(configuration.OpaqueId, configuration.OpaqueIdPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("--opaque-id: %s", err)
 	}
 
+	version, err := ResolveKibanaVersion(url, extraHeaders)
+	if err != nil {
+		Info.Println("Cannot resolve kibana version", err)
+		version = ""
+	}
+
+	return newHTTPClient(configuration, tlsConfig, KibanaDecorator{kibanaVersion: version, extraHeaders: extraHeaders,
+		queryParams: queryParams, proxyURL: proxyURL, proxyMethod: proxyMethod, runAs: configuration.RunAs,
+		opaqueId: opaqueId, configuration: configuration}), nil
+}
+
+// Creates the elasticsearch client for the configuration
+func newElasticClient(configuration *configuration.Configuration) (*elastic.Client, error) {
+	var url = resolveUrl(configuration)
+
 	defaultOptions := []elastic.ClientOptionFunc{
 		elastic.SetURL(url),
 		elastic.SetSniff(false),
 		elastic.SetHealthcheck(false),
+		elastic.SetRetrier(newStatusRetrier()),
+		elastic.SetRetryStatusCodes(retriedStatusCodes...),
 		//elastic.SetHealthcheckTimeoutStartup(10 * time.Second),
 		//elastic.SetHealthcheckTimeout(2 * time.Second),
 	}
@@ -87,61 +211,193 @@ func NewTail(configuration *configuration.Configuration) *Tail {
 	//		elastic.SetBasicAuth(configuration.User, configuration.Password))
 	//}
 
-	var cert = configuration.SearchTarget.Cert
-	var key = configuration.SearchTarget.Key
-	if cert != "" && key != "" {
-		cert, err := tls.LoadX509KeyPair(cert, key)
-		if err != nil {
-			Error.Fatalf("Bad certificate and/or key: %s", err)
-		}
-		tlsConfig := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		}
-		tlsConfig.BuildNameToCertificate()
-		transport := &http.Transport{TLSClientConfig: tlsConfig}
-		client := &http.Client{Transport: transport}
-		defaultOptions = append(defaultOptions, elastic.SetHttpClient(client))
-	}
-
 	if configuration.TraceRequests {
 		defaultOptions = append(defaultOptions,
 			elastic.SetTraceLog(Trace))
 	}
 
-	extraHeaders := map[string]string{}
-
-	for _, header := range configuration.SearchTarget.ExtraHeaders {
-		if header != "" {
-			tokenized := ExtractHeader(header)
-			extraHeaders[tokenized[0]] = tokenized[1]
-		}
-	}
-
-	version, err := ResolveKibanaVersion(url, extraHeaders)
+	httpClient, err := newKibanaHTTPClient(configuration, url)
 	if err != nil {
-		Info.Println("Cannot resolve kibana version", err)
-		version = ""
+		return nil, err
 	}
-
-	httpClient := &http.Client{Transport: KibanaDecorator{r: http.DefaultTransport, kibanaVersion: version, extraHeaders: extraHeaders, configuration: configuration}}
 	defaultOptions = append(defaultOptions, elastic.SetHttpClient(httpClient))
 
-	client, err = elastic.NewClient(defaultOptions...)
+	client, err := elastic.NewClient(defaultOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect Elasticsearch client to %s: %s", url, err)
+	}
+	return client, nil
+}
 
+// NewTail creates a new Tailer using configuration
+func NewTail(configuration *configuration.Configuration) *Tail {
+	client, err := newElasticClient(configuration)
 	if err != nil {
-		Error.Fatalf("Could not connect Elasticsearch client to %s: %s.", url, err)
+		Error.Fatalf("Failed creating ElasticSearch client: %s", err)
 	}
+	return newTailWithClient(configuration, client)
+}
+
+// Creates a new Tailer using configuration and the given client
+func newTailWithClient(configuration *configuration.Configuration, client *elastic.Client) *Tail {
+	tail := new(Tail)
+	var err error
 	tail.client = client
 
 	tail.queryDefinition = &configuration.QueryDefinition
 
 	tail.raw = configuration.Raw
+	if err := validateOutput(configuration.Output); err != nil {
+		Error.Fatalf("Invalid output mode: %s", err)
+	}
+	tail.ndjson = configuration.Output == outputNDJSON
+	if configuration.OutputFile != "" {
+		sink, err := newFileSink(configuration.OutputFile)
+		if err != nil {
+			Error.Fatalf("Failed creating output file: %s", err)
+		}
+		tail.sinks = append(tail.sinks, sink)
+	}
+	tail.fields = parseFieldList(configuration.Fields)
+	if err := validateFieldsOrder(configuration.OutputFieldsOrder); err != nil {
+		Error.Fatalf("Invalid --output-fields-order: %s", err)
+	}
+	tail.sortFields = configuration.OutputFieldsOrder == fieldsOrderSorted
+	if configuration.Template != "" && configuration.TemplateFile != "" {
+		Error.Fatalln("Options --template and --template-file are mutually exclusive.")
+	}
+	if configuration.Template != "" {
+		tail.template, err = newInlineTemplate(configuration.Template)
+	} else if configuration.TemplateFile != "" {
+		tail.template, err = loadTemplateFile(configuration.TemplateFile, configuration.Watch)
+	}
+	if err != nil {
+		Error.Fatalf("Invalid template: %s", err)
+	}
+	tail.output = bufio.NewWriter(os.Stdout)
+	tail.lineBuffered = configuration.LineBuffered
+	tail.explain = configuration.Explain
+	tail.runtimeMappings, tail.runtimeFields, err = ParseRuntimeFields(configuration.RuntimeFields)
+	if err != nil {
+		Error.Fatalf("Invalid runtime field: %s", err)
+	}
+	tail.termQueries, err = ParseTermHelpers(configuration.QueryDefinition.Wildcards, configuration.QueryDefinition.Fuzzies)
+	if err != nil {
+		Error.Fatalf("Invalid --wildcard or --fuzzy: %s", err)
+	}
+	tail.termQueries = append(tail.termQueries,
+		phraseQueries(configuration.QueryDefinition.PhraseField, configuration.QueryDefinition.Phrases)...)
+	tail.renames, err = ParseRenames(configuration.Renames)
+	if err != nil {
+		Error.Fatalf("Invalid field rename: %s", err)
+	}
+	tail.decoders, err = ParseDecoders(configuration.Decode)
+	if err != nil {
+		Error.Fatalf("Invalid --decode: %s", err)
+	}
+	tail.coercions, err = ParseCoercions(configuration.Coerce)
+	if err != nil {
+		Error.Fatalf("Invalid --coerce: %s", err)
+	}
+	if configuration.QueryDefinition.ImportQuery != "" {
+		//query of the saved query is added to the terms in main, only the filters are needed here
+		imported, err := LoadKibanaQuery(configuration.QueryDefinition.ImportQuery)
+		if err != nil {
+			Error.Fatalf("Failed reading --import-query: %s", err)
+		}
+		tail.importedFilters = kibanaFilterQueries(imported.Filters)
+	}
+	tail.color, err = colorEnabled(configuration.Color, os.Stdout)
+	if err != nil {
+		Error.Fatalf("Invalid color mode: %s", err)
+	}
+	if configuration.TimeLayout != "" {
+		tail.timeLayout, err = resolveTimeLayout(configuration.TimeLayout)
+		if err != nil {
+			Error.Fatalf("Invalid --time-layout: %s", err)
+		}
+	}
+	tail.concurrency = configuration.Concurrency
+	tail.batchSize = configuration.BatchSize
+	if err := validateTruncation(configuration.OnTruncation); err != nil {
+		Error.Fatalf("Invalid --on-truncation: %s", err)
+	}
+	tail.onTruncation = configuration.OnTruncation
+	tail.maxDedupIDs = configuration.MaxDedupIDs
+	tail.dedupIdField = configuration.DedupIdField
+	tail.trackUpdates = configuration.TrackUpdates
+	tail.nanos = configuration.Nanos
+	if configuration.ReplayDedupLines > 0 {
+		tail.printedLines = newLineHistory(configuration.ReplayDedupLines)
+	}
+	if configuration.Uniq || configuration.UniqCount {
+		tail.repeatedLines = newRepeatedLines(configuration.UniqCount)
+	}
+	tail.tailOnly = configuration.TailOnly
+	tail.afterId = configuration.AfterId
+	tail.pollOnDemand = configuration.PollOnDemand
+	tail.waitTimeout = configuration.WaitTimeout
+	tail.heartbeat = configuration.Heartbeat
+	tail.maxIterations = configuration.MaxIterations
+	tail.statsInterval = configuration.StatsInterval
+	tail.preference = configuration.Preference
+	tail.allowMissing = configuration.AllowMissing
+	tail.escape = configuration.Escape
+	tail.smart = configuration.Smart
+	tail.showIndex = configuration.ShowIndex
+	tail.useFieldsAPI = configuration.UseFieldsAPI
+	tail.unescape = parseFieldList(configuration.Unescape)
+	tail.unordered = configuration.Unordered
+	tail.reindexFormat = configuration.ReindexFormat
+	tail.missingField = configuration.MissingPlaceholder
+	tail.terminateAfter = configuration.TerminateAfter
+	if configuration.QueryDefinition.ExcludeTime != "" {
+		tail.excludedWindow, err = parseTimeOfDayWindow(configuration.QueryDefinition.ExcludeTime)
+		if err != nil {
+			Error.Fatalf("Invalid --exclude-time: %s", err)
+		}
+	}
+	tail.highlightFields = parseFieldList(configuration.HighlightFields)
+	if len(tail.highlightFields) > 0 {
+		tail.highlight = newHighlight(tail.highlightFields, tail.color)
+	}
+	if configuration.AutoFields && !tail.raw && !tail.smart && !tail.ndjson && tail.template == nil {
+		tail.sourceFields = tail.autoSourceFields()
+		Trace.Printf("Fetching only fields referenced in format: %v", tail.sourceFields)
+	}
+
+	if configuration.Grep != "" {
+		tail.grep, err = regexp.Compile(configuration.Grep)
+		if err != nil {
+			Error.Fatalf("Invalid --grep regular expression: %s", err)
+		}
+	}
+	if configuration.GrepInvert != "" {
+		tail.grepInvert, err = regexp.Compile(configuration.GrepInvert)
+		if err != nil {
+			Error.Fatalf("Invalid --grep-v regular expression: %s", err)
+		}
+	}
 
+	configuration.SearchTarget.IndexPattern, err = ResolveIndexPattern(configuration.SearchTarget.IndexPattern,
+		configuration.Env, os.LookupEnv)
+	if err != nil {
+		Error.Fatalf("Invalid index pattern: %s", err)
+	}
 	tail.indices = []string{configuration.SearchTarget.IndexPattern}
 	//tail.selectIndices(configuration)
+	if configuration.IndexOffset > 0 {
+		tail.selectIndexByOffset(configuration)
+	}
+
+	tail.sortField, err = ParseSortField(configuration.Sort)
+	if err != nil {
+		Error.Fatalf("Invalid --sort: %s", err)
+	}
 
-	//If we're date filtering on start date, then the sort needs to be ascending
-	if configuration.QueryDefinition.AfterDateTime != "" {
+	//If we're date filtering on start date or listing the oldest entries, then the sort needs to be ascending.
+	//Entries sorted by a field are printed in the order they are returned.
+	if configuration.QueryDefinition.AfterDateTime != "" || configuration.Head || tail.sortField != nil {
 		tail.order = true //ascending
 	} else {
 		tail.order = false //descending
@@ -149,25 +405,98 @@ func NewTail(configuration *configuration.Configuration) *Tail {
 	return tail
 }
 
+// Builds TLS configuration with client certificate and key if they are configured, otherwise returns nil.
+// PEM data given directly takes precedence over certificate and key files.
+func loadTLSConfig(configuration *configuration.Configuration) (*tls.Config, error) {
+	var cert = configuration.SearchTarget.Cert
+	var key = configuration.SearchTarget.Key
+	var certData = configuration.SearchTarget.CertData
+	var keyData = configuration.SearchTarget.KeyData
+	var keyPair tls.Certificate
+	minVersion, err := parseTLSVersion(configuration.SearchTarget.TLSMinVersion)
+	if err != nil {
+		return nil, err
+	}
+	if certData != "" && keyData != "" {
+		keyPair, err = tls.X509KeyPair([]byte(certData), []byte(keyData))
+	} else if cert != "" && key != "" {
+		keyPair, err = tls.LoadX509KeyPair(cert, key)
+	} else if minVersion != 0 {
+		return &tls.Config{MinVersion: minVersion}, nil
+	} else {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{keyPair},
+		MinVersion:   minVersion,
+	}
+	tlsConfig.BuildNameToCertificate()
+	return tlsConfig, nil
+}
+
+// Parses --tls-min-version, returning 0 (Go default) if it's not set
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "":
+		return 0, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %s, expected 1.2 or 1.3", version)
+}
+
+// Resolves the URL elasticsearch client should connect to. Adds the http:// prefix and default port if they are
+// missing and points to the SSH tunnel if one was created.
+func resolveUrl(configuration *configuration.Configuration) string {
+	//if a tunnel is successfully created, we need to connect to tunnel url (which is localhost on tunnel port)
+	if configuration.SearchTarget.TunnelUrl != "" {
+		return configuration.SearchTarget.TunnelUrl
+	}
+	return targetUrl(configuration)
+}
+
+// Resolves the URL of the search target as configured, regardless of the SSH tunnel
+func targetUrl(configuration *configuration.Configuration) string {
+	var url = configuration.SearchTarget.Url
+	if !strings.HasPrefix(url, "http") {
+		url = "http://" + url
+		Trace.Printf("Adding http:// prefix to given url. Url: " + url)
+	}
+
+	if !Must(regexp.MatchString(".*:\\d+", url)) && Must(regexp.MatchString("http://[^/]+$", url)) {
+		url += ":9200"
+		Trace.Printf("No port was specified, adding default port 9200 to given url. Url: " + url)
+	}
+	return url
+}
+
 // Selects appropriate indices in EL based on configuration. This basically means that if query is date filtered,
 // then it attempts to select indices in the filtered date range, otherwise it selects the last index.
 func (tail *Tail) selectIndices(configuration *configuration.Configuration) {
-	result, err := tail.client.CatIndices().Do(context.TODO())
-	if err != nil {
-		Info.Println("Could not fetch available indices. Using pattern instead.", err)
+	if isDateMathIndex(configuration.SearchTarget.IndexPattern) {
+		//date math index names are resolved by elasticsearch itself, no need to narrow them down
 		tail.indices = []string{configuration.SearchTarget.IndexPattern}
+		Info.Printf("Using date math index: %s", tail.indices)
 		return
 	}
-	indices := make([]string, len(result))
-	for i, response := range result {
-		indices[i] = response.Index
+	indices, err := tail.listIndexNames()
+	if err != nil {
+		reportCatIndicesError(err)
+		tail.indices = []string{configuration.SearchTarget.IndexPattern}
+		return
 	}
+	indexPattern := indexPatternRegexp(configuration)
 
 	if configuration.QueryDefinition.IsDateTimeFiltered() {
 		startDate := configuration.QueryDefinition.AfterDateTime
 		endDate := configuration.QueryDefinition.BeforeDateTime
 		if startDate == "" && endDate != "" {
-			lastIndex := findLastIndex(indices, configuration.SearchTarget.IndexPattern)
+			lastIndex := findLastIndex(indices, indexPattern)
 			lastIndexDate := extractYMDDate(lastIndex, ".")
 			if lastIndexDate.Before(extractYMDDate(endDate, "-")) {
 				startDate = lastIndexDate.Format(dateFormatDMY)
@@ -178,201 +507,1362 @@ func (tail *Tail) selectIndices(configuration *configuration.Configuration) {
 		if endDate == "" {
 			endDate = time.Now().Format(dateFormatDMY)
 		}
-		tail.indices = findIndicesForDateRange(indices, configuration.SearchTarget.IndexPattern, startDate, endDate)
+		tail.indices = findIndicesForDateRange(indices, indexPattern, startDate, endDate)
 
 	} else {
-		index := findLastIndex(indices, configuration.SearchTarget.IndexPattern)
+		index := findLastIndex(indices, indexPattern)
 		result := [...]string{index}
 		tail.indices = result[:]
 	}
 	Info.Printf("Using indices: %s", tail.indices)
 }
 
+// Selects the index the given number of generations before the latest one matched by the index pattern, e.g. 1 for
+// the index before the current one of rollover indices
+func (tail *Tail) selectIndexByOffset(configuration *configuration.Configuration) {
+	if isDateMathIndex(configuration.SearchTarget.IndexPattern) {
+		Error.Fatalln("Option --index-offset can't be used with date math index names.")
+	}
+	indices, err := tail.listIndexNames()
+	if err != nil {
+		Error.Fatalln("Failed listing indices for --index-offset.", err)
+	}
+	index, err := findIndexByOffset(indices, indexPatternRegexp(configuration), configuration.IndexOffset)
+	if err != nil {
+		Error.Fatalln("Invalid --index-offset.", err)
+	}
+	tail.indices = []string{index}
+	Info.Printf("Using index: %s", index)
+}
+
+// Returns names of all the indices using cat indices
+func (tail *Tail) listIndexNames() ([]string, error) {
+	result, err := tail.client.CatIndices().Do(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	indices := make([]string, len(result))
+	for i, response := range result {
+		indices[i] = response.Index
+	}
+	return indices, nil
+}
+
+// Returns the index pattern as regular expression index names are matched with
+func indexPatternRegexp(configuration *configuration.Configuration) string {
+	if configuration.UnanchoredIndexPattern {
+		return configuration.SearchTarget.IndexPattern
+	}
+	return anchorIndexPattern(configuration.SearchTarget.IndexPattern)
+}
+
 // Start the tailer
 func (tail *Tail) Start(follow bool, initialEntries int) {
 
-	result, err := tail.initialSearch(initialEntries)
-	if err != nil {
-		Error.Fatalln("Error in executing search query.", err)
+	var result *elastic.SearchResult
+	var err error
+	tail.prepare(follow)
+	if follow && tail.tailOnly {
+		//skip the initial entries, seeding the timestamp makes follow loop fetch only entries arriving from now on
+		tail.lastTimeStamp = tail.formatTimeStamp(time.Now().UTC())
+	} else if follow && tail.afterId != "" {
+		if err := tail.seedFromDocument(tail.afterId); err != nil {
+			Error.Fatalln("Failed looking up the --after-id document.", describeSearchError(err))
+		}
+	} else if !follow && initialEntries > maxResultWindow {
+		if err := tail.pagedSearch(initialEntries); err != nil {
+			Error.Fatalln("Error in executing search query.", describeSearchError(err))
+		}
+	} else {
+		if !follow && tail.concurrency > 1 {
+			result, err = tail.concurrentSearch(initialEntries)
+		} else {
+			result, err = tail.initialSearch(initialEntries)
+		}
+		if err != nil {
+			Error.Fatalln("Error in executing search query.", describeSearchError(err))
+		}
+		tail.processResults(result)
+	}
+	var polls <-chan string
+	if follow && tail.pollOnDemand {
+		fmt.Fprintln(os.Stderr, "Press Enter to fetch new entries, Ctrl-D to quit.")
+		polls = readLines(os.Stdin)
+	}
+	var beat *heartbeat
+	if follow && tail.heartbeat > 0 {
+		beat = newHeartbeat(tail.heartbeat)
+		beat.polled(tail.printedEntries)
+		go beat.run(os.Stderr)
+	}
+	if follow && tail.statsInterval > 0 {
+		//entries of the initial search are not counted, so the stats show the volume arriving while tailing
+		tail.stats = newThroughputStats(tail.statsInterval)
+		go tail.stats.run(os.Stderr)
 	}
-	tail.processResults(result)
 	delay := 500 * time.Millisecond
-	for follow {
-		time.Sleep(delay)
-		if tail.lastTimeStamp != "" {
-			Info.Printf("Query: %v\n", tail.buildTimestampFilteredQuery())
-			searchRequest := elastic.NewSearchRequest().
-				Sort(tail.queryDefinition.TimestampField, false).
-				From(0).
-				Size(9000). //TODO: needs rewrite this using scrolling, as this implementation may loose entries if there's more than 9K entries per sleep period
-				Query(tail.buildTimestampFilteredQuery())
-
-			//we can execute follow up timestamp filtered query only if we fetched at least 1 result in initial query
-			multiResult, e := tail.client.MultiSearch().
-				Index(tail.indices...).
-				Add(searchRequest).
-				Do(context.Background())
-
-			if multiResult != nil {
-				result = multiResult.Responses[0]
-				err = nil
-			} else {
-				result = nil
-				err = e
+	waitStarted := time.Now()
+	for iteration := 0; follow && !tail.isLastIteration(iteration); iteration++ {
+		if polls != nil {
+			if _, ok := <-polls; !ok {
+				break
 			}
-
 		} else {
-			//if lastTimeStamp is not defined we have to repeat the initial search until we get at least 1 result
-			result, err = tail.initialSearch(initialEntries)
-			Info.Printf("Query: %s\n", tail.buildTimestampFilteredQuery())
+			time.Sleep(delay)
 		}
+		result, err = tail.poll(initialEntries)
 		if err != nil {
-			Error.Fatalln("Error in executing search query.", err)
+			Error.Fatalln("Error in executing search query.", describeSearchError(err))
 		}
 		tail.processResults(result)
-
-		//Dynamic delay calculation for determining delay between search requests
-		if result.TotalHits() > 0 && delay > 500*time.Millisecond {
-			delay = 500 * time.Millisecond
-		} else if delay <= 2000*time.Millisecond {
-			delay = delay + 500*time.Millisecond
+		if beat != nil {
+			beat.polled(tail.printedEntries)
+		}
+		if tail.lastTimeStamp == "" {
+			tail.waitForFirstEntry(time.Since(waitStarted))
+		}
+		delay = nextPollDelay(delay, result.TotalHits() > 0)
+		if tail.stats != nil {
+			tail.stats.setDelay(delay)
 		}
 	}
+	tail.finishRepeatedLines()
+	tail.closeSinks()
 }
 
-// Initial search needs to be run until we get at least one result
-// in order to fetch the timestamp which we will use in subsequent follow searches
-func (tail *Tail) initialSearch(initialEntries int) (*elastic.SearchResult, error) {
-	searchRequest := elastic.NewSearchRequest().
-		Sort(tail.queryDefinition.TimestampField, tail.order).
-		Query(tail.buildSearchQuery()).
-		From(0).Size(initialEntries)
+// Returns true if the follow loop made --max-iterations polls already
+func (tail *Tail) isLastIteration(iteration int) bool {
+	return tail.maxIterations > 0 && iteration >= tail.maxIterations
+}
 
-	result, e := tail.client.MultiSearch().
-		Index(tail.indices...).
-		Add(searchRequest).
-		Do(context.Background())
-	if result != nil {
-		return result.Responses[0], nil
-	} else {
-		return nil, e
+// Validates follow mode settings and detects timestamp precision before the first search
+func (tail *Tail) prepare(follow bool) {
+	tail.follow = follow
+	if follow {
+		if err := tail.validateBatchSize(); err != nil {
+			Error.Fatalln("Invalid --batch-size.", err)
+		}
+		if !tail.nanos {
+			tail.nanos = tail.detectDateNanos()
+		}
 	}
+}
 
-	// return tail.client.Search().
-	// 	Index(tail.indices...).
-	// 	Sort(tail.queryDefinition.TimestampField, tail.order).
-	// 	Query(tail.buildSearchQuery()).
-	// 	From(0).Size(initialEntries).
-	// 	Do(context.Background())
+// Fetches the entries that arrived since the last poll
+func (tail *Tail) poll(initialEntries int) (*elastic.SearchResult, error) {
+	if tail.lastTimeStamp == "" {
+		//if lastTimeStamp is not defined we have to repeat the initial search until we get at least 1 result
+		result, err := tail.initialSearch(initialEntries)
+		Info.Printf("Query: %s\n", tail.buildTimestampFilteredQuery())
+		return result, err
+	}
+	Info.Printf("Query: %v\n", tail.buildTimestampFilteredQuery())
+	searchRequest := tail.newSearchRequest().
+		Sort(tail.queryDefinition.TimestampField, false).
+		From(0).
+		Size(tail.batchSize). //TODO: needs rewrite this using scrolling, as this implementation may loose entries if there's more than batch size entries per sleep period
+		Query(tail.buildTimestampFilteredQuery())
+
+	//we can execute follow up timestamp filtered query only if we fetched at least 1 result in initial query
+	result, err := tail.executeSearch(tail.indices, searchRequest)
+	if err == nil {
+		tail.checkTruncation(result)
+	}
+	return result, err
 }
 
-// Process the results (e.g. prints them out based on configured format)
-func (tail *Tail) processResults(searchResult *elastic.SearchResult) {
-	Trace.Printf("Fetched page of %d results out of %d total.\n", len(searchResult.Hits.Hits), searchResult.TotalHits())
-	hits := searchResult.Hits.Hits
+// Dynamic delay calculation for determining delay between search requests
+func nextPollDelay(delay time.Duration, found bool) time.Duration {
+	if found && delay > 500*time.Millisecond {
+		return 500 * time.Millisecond
+	} else if delay <= 2000*time.Millisecond {
+		return delay + 500*time.Millisecond
+	}
+	return delay
+}
 
-	// We need to track last N entries that had the timestamp newer than cutoff timestamp. This is done to
-	// avoid loosing entries that may have arrived to elasticsearch just as we were executing next query.
-	// When tailing, we will
-	// issue next query which will be filtered so that timestamps are greater or
-	// equal to last timestamp minus tailing time window. Since we are tracking IDs of entries form previous query,
-	// we can use the IDs to remove the duplicates. https://github.com/knes1/elktail/issues/11
+// Seeds the follow state with the timestamp of the document with the given _id, tracking it as already printed, so
+// following starts right after it. Document is looked up with a search, as Get API is not available through Kibana.
+func (tail *Tail) seedFromDocument(id string) error {
+	searchRequest := tail.newSearchRequest().Query(elastic.NewIdsQuery().Ids(id)).Size(1)
+	result, err := tail.executeSearch(tail.indices, searchRequest)
+	if err != nil {
+		return err
+	}
+	if len(result.Hits.Hits) == 0 {
+		return fmt.Errorf("document %s not found in %s", id, strings.Join(tail.indices, ","))
+	}
+	hit := result.Hits.Hits[0]
+	var entry map[string]interface{}
+	if tail.useFieldsAPI {
+		entry = fieldsEntry(hit.Fields)
+	} else if entry, err = parseEntry(hit.Source); err != nil {
+		return err
+	}
+	timeStamp, ok := entry[tail.queryDefinition.TimestampField].(string)
+	if !ok {
+		return fmt.Errorf("document %s has no %s timestamp", id, tail.queryDefinition.TimestampField)
+	}
+	Info.Printf("Following entries after %s from %s\n", timeStamp, id)
+	tail.lastTimeStamp = timeStamp
+	tail.trackEntry(hit, entry, timeStamp)
+	return nil
+}
 
-	if tail.order {
-		for i := 0; i < len(hits); i++ {
-			hit := hits[i]
-			entry := tail.processHit(hit)
-			timeStamp := entry[tail.queryDefinition.TimestampField].(string)
-			if timeStamp != tail.lastTimeStamp {
-				tail.lastTimeStamp = timeStamp
-			}
-			tail.lastIDs = append(tail.lastIDs, displayedEntry{timeStamp: timeStamp, id: hit.Id})
-		}
+// Interval of reporting that no entry matching the query arrived yet
+const waitingReportInterval = 30 * time.Second
 
-	} else { //when results are in descending order, we need to process them in reverse
-		for i := len(hits) - 1; i >= 0; i-- {
-			hit := hits[i]
-			entry := tail.processHit(hit)
-			timeStamp := entry[tail.queryDefinition.TimestampField].(string)
-			if timeStamp != tail.lastTimeStamp {
-				tail.lastTimeStamp = timeStamp
-			}
-			tail.lastIDs = append(tail.lastIDs, displayedEntry{timeStamp: timeStamp, id: hit.Id})
-		}
+// Reports (at most every waitingReportInterval) that follow mode is still waiting for the first entry matching the
+// query, so an empty result doesn't look like a hang, and exits if --wait-timeout elapsed
+func (tail *Tail) waitForFirstEntry(waited time.Duration) {
+	if tail.waitTimeout > 0 && waited >= tail.waitTimeout {
+		Error.Fatalf("No entry matching the query arrived within %s.\n", tail.waitTimeout)
+	}
+	if time.Since(tail.waitingReported) >= waitingReportInterval {
+		fmt.Fprintf(os.Stderr, "Waiting for the first entry matching the query (%s so far)...\n", waited.Round(time.Second))
+		tail.waitingReported = time.Now()
 	}
-	cutoffTime := formatElasticTimeStamp(parseElasticTimeStamp(tail.lastTimeStamp).Add(-tailingTimeWindow * time.Millisecond))
-	drainOldEntries(&tail.lastIDs, cutoffTime)
-	//fmt.Print("------------------------------------------------\n")
-	//Debugging IDs
-	//Info.Printf("CutOff time: %s", cutoffTime)
-	//Info.Printf("IDs: %v", tail.lastIDs)
 }
 
-func parseElasticTimeStamp(elTimeStamp string) time.Time {
-	timeStr, _ := time.Parse(dateFormatFull, elTimeStamp)
-	return timeStr
+// Sends each line read from the reader to the returned channel, which is closed when reader reaches the end
+func readLines(reader io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	return lines
 }
 
-func formatElasticTimeStamp(timeStamp time.Time) string {
+// Checks that truncation handling is one of the supported ones
+func validateTruncation(onTruncation string) error {
+	switch onTruncation {
+	case truncationWarn, truncationFail, truncationIgnore, "":
+		return nil
+	}
+	return fmt.Errorf("%s is not one of %s, %s or %s", onTruncation, truncationWarn, truncationFail, truncationIgnore)
+}
+
+// Warns (or fails, depending on --on-truncation) when a poll returned a full batch, as entries beyond the batch
+// that arrived in the same poll period are probably lost
+func (tail *Tail) checkTruncation(result *elastic.SearchResult) {
+	if tail.trackUpdates && result.Hits != nil {
+		//displayed entries are fetched again with --track-updates, only new or updated ones count
+		var fetched []*elastic.SearchHit
+		for _, hit := range result.Hits.Hits {
+			if !tail.isDisplayed(hit) {
+				fetched = append(fetched, hit)
+			}
+		}
+		result = newSearchResult(fetched, result.TotalHits())
+	}
+	message := truncationMessage(result, tail.batchSize)
+	if message == "" {
+		return
+	}
+	switch tail.onTruncation {
+	case truncationIgnore:
+		Info.Println(message)
+	case truncationFail:
+		Error.Fatalln(message)
+	default:
+		Error.Println(message)
+	}
+}
+
+// Returns the warning for a poll result which reached the batch size, empty if it wasn't truncated
+func truncationMessage(result *elastic.SearchResult, batchSize int) string {
+	if result.Hits == nil || len(result.Hits.Hits) < batchSize {
+		return ""
+	}
+	return fmt.Sprintf("Poll returned %d entries (the --batch-size limit) out of %d matching, some entries were "+
+		"probably skipped. Increase --batch-size or narrow down the query.", len(result.Hits.Hits), result.TotalHits())
+}
+
+// Checks that batch size is within the result window of the tailed indices. Window is only looked up on the server
+// when batch size exceeds the default, as index.max_result_window setting may have been raised.
+func (tail *Tail) validateBatchSize() error {
+	if tail.batchSize < 1 {
+		return fmt.Errorf("batch size must be positive")
+	}
+	if tail.batchSize <= maxResultWindow {
+		return nil
+	}
+	window := tail.resultWindow()
+	if tail.batchSize > window {
+		return fmt.Errorf("batch size %d exceeds max_result_window (%d) of the indices", tail.batchSize, window)
+	}
+	return nil
+}
+
+// Returns the smallest index.max_result_window of the tailed indices, falling back to the elasticsearch default
+// if settings can not be read (e.g. through Kibana proxy)
+func (tail *Tail) resultWindow() int {
+	settings, err := tail.client.IndexGetSettings(tail.indices...).
+		Name("index.max_result_window").
+		FlatSettings(true).
+		Do(context.TODO())
+	if err != nil {
+		Trace.Printf("Failed reading max_result_window of indices: %s", err)
+		return maxResultWindow
+	}
+	window := 0
+	for _, indexSettings := range settings {
+		indexWindow := maxResultWindow
+		if value, ok := indexSettings.Settings["index.max_result_window"].(string); ok {
+			if parsed, err := strconv.Atoi(value); err == nil {
+				indexWindow = parsed
+			}
+		}
+		if window == 0 || indexWindow < window {
+			window = indexWindow
+		}
+	}
+	if window == 0 {
+		return maxResultWindow
+	}
+	return window
+}
+
+// Initial search needs to be run until we get at least one result
+// in order to fetch the timestamp which we will use in subsequent follow searches
+func (tail *Tail) initialSearch(initialEntries int) (*elastic.SearchResult, error) {
+	return tail.executeSearch(tail.indices, tail.buildInitialSearchRequest(initialEntries))
+
+	// return tail.client.Search().
+	// 	Index(tail.indices...).
+	// 	Sort(tail.queryDefinition.TimestampField, tail.order).
+	// 	Query(tail.buildSearchQuery()).
+	// 	From(0).Size(initialEntries).
+	// 	Do(context.Background())
+}
+
+// Builds the clause matching entries that match at least one of the --any query strings
+func (tail *Tail) buildAnyQuery() elastic.Query {
+	var queries []elastic.Query
+	for _, term := range tail.queryDefinition.AnyTerms {
+		if tail.escape {
+			term = escapeQueryString(term)
+		}
+		queries = append(queries, elastic.NewQueryStringQuery(term))
+	}
+	Trace.Printf("Running any of query string queries: %v", tail.queryDefinition.AnyTerms)
+	return elastic.NewBoolQuery().Should(queries...).MinimumShouldMatch("1")
+}
+
+// Fields set by Filebeat's add_kubernetes_metadata processor
+const (
+	k8sNamespaceField = "kubernetes.namespace"
+	k8sPodField       = "kubernetes.pod.name"
+	k8sContainerField = "kubernetes.container.name"
+)
+
+// Builds term filters for --k8s-namespace, --k8s-pod and --k8s-container
+func (tail *Tail) buildKubernetesFilters() []elastic.Query {
+	var filters []elastic.Query
+	for _, filter := range [][2]string{
+		{k8sNamespaceField, tail.queryDefinition.K8sNamespace},
+		{k8sPodField, tail.queryDefinition.K8sPod},
+		{k8sContainerField, tail.queryDefinition.K8sContainer},
+	} {
+		field, value := filter[0], filter[1]
+		if value != "" {
+			Trace.Printf("Kubernetes filter - %s: %s", field, value)
+			filters = append(filters, elastic.NewTermQuery(field, value))
+		}
+	}
+	return filters
+}
+
+// Creates a search request with options common to all searches applied
+func (tail *Tail) newSearchRequest() *elastic.SearchRequest {
+	searchRequest := elastic.NewSearchRequest()
+	if len(tail.runtimeFields) > 0 || len(tail.sourceFields) > 0 || tail.trackUpdates {
+		searchSource := elastic.NewSearchSource()
+		if len(tail.runtimeFields) > 0 {
+			//runtime fields are not part of the source, so they have to be fetched as docvalue fields
+			searchSource = searchSource.
+				RuntimeMappings(tail.runtimeMappings).
+				DocvalueFields(tail.runtimeFields...)
+		}
+		if len(tail.sourceFields) > 0 {
+			searchSource = searchSource.FetchSourceIncludeExclude(tail.sourceFields, nil)
+		}
+		if tail.trackUpdates {
+			searchSource = searchSource.SeqNoAndPrimaryTerm(true)
+		}
+		searchRequest = searchRequest.SearchSource(searchSource)
+	}
+	if tail.preference != "" {
+		searchRequest = searchRequest.Preference(tail.preference)
+	}
+	if tail.allowMissing {
+		searchRequest = searchRequest.IgnoreUnavailable(true).AllowNoIndices(true)
+	}
+	if tail.explain {
+		searchRequest = searchRequest.Explain(true)
+	}
+	if tail.queryDefinition.MinScore > 0 {
+		searchRequest = searchRequest.MinScore(tail.queryDefinition.MinScore).TrackScores(true)
+	}
+	if tail.highlight != nil {
+		searchRequest = searchRequest.Highlight(tail.highlight)
+	}
+	if tail.terminateAfter > 0 {
+		searchRequest = searchRequest.TerminateAfter(tail.terminateAfter)
+	}
+	return searchRequest
+}
+
+// PrintDocument fetches a single document by its _id using the Get API and prints it (raw or formatted)
+func (tail *Tail) PrintDocument(index string, id string) error {
+	if index == "" {
+		return fmt.Errorf("index of the document must be given with --index")
+	}
+	getService := tail.client.Get().Index(index).Id(id)
+	if tail.preference != "" {
+		getService = getService.Preference(tail.preference)
+	}
+	result, err := getService.Do(context.TODO())
+	if err != nil {
+		return err
+	}
+	if !result.Found {
+		return fmt.Errorf("document %s not found in index %s", id, index)
+	}
+	tail.processHit(&elastic.SearchHit{Index: result.Index, Id: result.Id, Source: result.Source})
+	return tail.output.Flush()
+}
+
+// Fetches more entries than elasticsearch returns in a single search (max_result_window) by paging with
+// search_after. When entries are sorted in descending order, pages are collected and processed together so
+// that entries are printed in chronological order.
+func (tail *Tail) pagedSearch(initialEntries int) error {
+	fmt.Fprintf(os.Stderr, "Requested %d entries, which is more than the %d returned by a single search. "+
+		"Fetching them in pages using search_after...\n", initialEntries, maxResultWindow)
+	var collected []*elastic.SearchHit
+	var searchAfter []interface{}
+	var totalHits int64
+	for remaining := initialEntries; remaining > 0; {
+		size := remaining
+		if size > maxResultWindow {
+			size = maxResultWindow
+		}
+		searchRequest := tail.buildInitialSearchRequest(size)
+		if searchAfter != nil {
+			searchRequest = searchRequest.SearchAfter(searchAfter...)
+		}
+		//tiebreaker so that entries with the same timestamp are not skipped between pages. The id field is unique,
+		//_doc only within a shard.
+		if tail.dedupIdField != "" {
+			searchRequest = searchRequest.Sort(tail.dedupIdField, tail.order)
+		} else {
+			searchRequest = searchRequest.Sort("_doc", tail.order)
+		}
+		result, err := tail.executeSearch(tail.indices, searchRequest)
+		if err != nil {
+			return err
+		}
+		hits := result.Hits.Hits
+		totalHits = result.TotalHits()
+		Info.Printf("Fetched page of %d entries, %d remaining.", len(hits), remaining-len(hits))
+		if tail.order {
+			tail.processResults(result)
+		} else {
+			collected = append(collected, hits...)
+		}
+		if len(hits) < size {
+			break
+		}
+		remaining -= len(hits)
+		searchAfter = hits[len(hits)-1].Sort
+	}
+	if !tail.order {
+		tail.processResults(newSearchResult(collected, totalHits))
+	}
+	return nil
+}
+
+// Builds the search request for the initial search (latest or earliest entries depending on order)
+func (tail *Tail) buildInitialSearchRequest(initialEntries int) *elastic.SearchRequest {
+	searchRequest := tail.newSearchRequest()
+	if tail.sortField != nil {
+		//timestamp breaks ties between entries with the same value
+		searchRequest = searchRequest.SortBy(tail.sortField)
+	}
+	return searchRequest.
+		Sort(tail.queryDefinition.TimestampField, tail.order).
+		Query(tail.buildSearchQuery()).
+		From(0).Size(initialEntries)
+}
+
+// Unmapped type used when sorting by a field that's not mapped in some of the indices, so that those indices
+// don't fail the search. Sorting is mostly done by numeric fields (durations, sizes).
+const defaultSortUnmappedType = "long"
+
+// ParseSortField parses --sort given in field[:asc|desc[:type]] format, where type is the field type assumed for
+// indices the field is not mapped in. Entries missing the field are sorted last. Returns nil if definition is empty.
+func ParseSortField(definition string) (*elastic.FieldSort, error) {
+	if definition == "" {
+		return nil, nil
+	}
+	parts := strings.Split(definition, ":")
+	if len(parts) > 3 || strings.TrimSpace(parts[0]) == "" {
+		return nil, fmt.Errorf("%s is not in field[:asc|desc[:type]] format", definition)
+	}
+	fieldSort := elastic.NewFieldSort(strings.TrimSpace(parts[0])).Desc().Missing("_last").UnmappedType(defaultSortUnmappedType)
+	if len(parts) > 1 {
+		switch strings.ToLower(strings.TrimSpace(parts[1])) {
+		case "asc":
+			fieldSort = fieldSort.Asc()
+		case "desc":
+		default:
+			return nil, fmt.Errorf("sort order %s is not asc or desc", parts[1])
+		}
+	}
+	if len(parts) > 2 {
+		fieldSort = fieldSort.UnmappedType(strings.TrimSpace(parts[2]))
+	}
+	return fieldSort, nil
+}
+
+// Executes the search request over given indices. Multi search is used (instead of plain search) as it's the
+// endpoint Kibana proxies
+func (tail *Tail) executeSearch(indices []string, searchRequest *elastic.SearchRequest) (*elastic.SearchResult, error) {
+	if tail.useFieldsAPI {
+		var err error
+		searchRequest, err = withFieldsAPI(searchRequest, tail.sourceFields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	started := time.Now()
+	result, e := tail.client.MultiSearch().
+		Index(indices...).
+		Add(searchRequest).
+		Do(context.Background())
+	if result != nil {
+		response := result.Responses[0]
+		if response.Error != nil {
+			err := &elastic.Error{Status: response.Status, Details: response.Error}
+			if tail.allowMissing && isIndexNotFound(err) {
+				//ignore_unavailable doesn't cover all the cases (e.g. aliases), so missing index is treated as empty
+				Info.Printf("Index %s doesn't exist yet, waiting for it.\n", strings.Join(indices, ","))
+				return &elastic.SearchResult{Hits: &elastic.SearchHits{}}, nil
+			}
+			return nil, err
+		}
+		Info.Println(formatSearchTiming(response, time.Since(started)))
+		return response, nil
+	} else {
+		return nil, e
+	}
+}
+
+// Formats server side and wall clock time of the search, e.g. "Query took 12ms server / 85ms wall, 50 hits". Big
+// difference between the two points to network or tunnel latency rather than a slow cluster.
+func formatSearchTiming(result *elastic.SearchResult, wall time.Duration) string {
+	hits := 0
+	if result.Hits != nil {
+		hits = len(result.Hits.Hits)
+	}
+	return fmt.Sprintf("Query took %dms server / %dms wall, %d hits", result.TookInMillis, wall.Milliseconds(), hits)
+}
+
+// Replaces _source in the search request with the fields API, so elasticsearch returns values formatted according
+// to the mapping (dates, ip, geo) and runtime fields along with the rest. All fields are requested if none are given.
+// Client library doesn't support the fields parameter, so it's added to the request body.
+func withFieldsAPI(searchRequest *elastic.SearchRequest, fields []string) (*elastic.SearchRequest, error) {
+	body, err := searchRequest.Body()
+	if err != nil {
+		return nil, err
+	}
+	var source map[string]interface{}
+	if err := json.Unmarshal([]byte(body), &source); err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		fields = []string{"*"}
+	}
+	source["fields"] = fields
+	source["_source"] = false
+	return searchRequest.Source(source), nil
+}
+
+// Creates a search result holding given hits, used when hits from several searches are combined
+func newSearchResult(hits []*elastic.SearchHit, totalHits int64) *elastic.SearchResult {
+	return &elastic.SearchResult{
+		Hits: &elastic.SearchHits{
+			TotalHits: &elastic.TotalHits{Value: totalHits, Relation: "eq"},
+			Hits:      hits,
+		},
+	}
+}
+
+// Describes the search error, adding a hint about escaping if elasticsearch failed to parse the query string, or
+// about the credentials or load if the request was rejected
+func describeSearchError(err error) string {
+	if hint := describeStatusError(err); hint != "" {
+		return fmt.Sprintf("%s\n%s", err, hint)
+	}
+	if elastic.IsStatusCode(err, http.StatusBadRequest) && strings.Contains(strings.ToLower(err.Error()), "parse") {
+		return fmt.Sprintf("%s\nElasticSearch could not parse the query. Characters such as / : [ ] ( ) have special "+
+			"meaning in the query string and need to be escaped with \\ - or use --escape to escape them all.", err)
+	}
+	if isIndexNotFound(err) {
+		return fmt.Sprintf("%s\nIndex doesn't exist. Use --allow-missing to wait for it to be created.", err)
+	}
+	return err.Error()
+}
+
+// Returns true if the search failed because the index doesn't exist
+func isIndexNotFound(err error) bool {
+	if e, ok := err.(*elastic.Error); ok && e.Details != nil {
+		return e.Details.Type == "index_not_found_exception"
+	}
+	return false
+}
+
+// Process the results (e.g. prints them out based on configured format)
+func (tail *Tail) processResults(searchResult *elastic.SearchResult) {
+	Trace.Printf("Fetched page of %d results out of %d total.\n", len(searchResult.Hits.Hits), searchResult.TotalHits())
+	hits := searchResult.Hits.Hits
+
+	// We need to track last N entries that had the timestamp newer than cutoff timestamp. This is done to
+	// avoid loosing entries that may have arrived to elasticsearch just as we were executing next query.
+	// When tailing, we will
+	// issue next query which will be filtered so that timestamps are greater or
+	// equal to last timestamp minus tailing time window. Since we are tracking IDs of entries form previous query,
+	// we can use the IDs to remove the duplicates. https://github.com/knes1/elktail/issues/11
+
+	rawFastPath := tail.isRawFastPath()
+	bytes := 0
+	for _, hit := range tail.orderedHits(hits) {
+		bytes += len(hit.Source)
+		tail.processResultHit(hit, rawFastPath)
+	}
+	if tail.stats != nil {
+		tail.stats.add(len(hits), bytes)
+	}
+	tail.finishResults()
+}
+
+// Returns the hits in the order they are displayed in: when results are in descending order, we need to process
+// them in reverse
+func (tail *Tail) orderedHits(hits []*elastic.SearchHit) []*elastic.SearchHit {
+	if tail.order {
+		return hits
+	}
+	reversed := make([]*elastic.SearchHit, len(hits))
+	for i, hit := range hits {
+		reversed[len(hits)-1-i] = hit
+	}
+	return reversed
+}
+
+// Prints the hit and tracks it as displayed
+func (tail *Tail) processResultHit(hit *elastic.SearchHit, rawFastPath bool) {
+	if tail.isDisplayed(hit) {
+		return
+	}
+	if rawFastPath {
+		if tail.printLine(hit, string(hit.Source), nil) {
+			tail.writeToSinks(hit, nil, hit.Source)
+		}
+		return
+	}
+	entry, timeStamp := tail.processHit(hit)
+	if timeStamp == "" {
+		if !tail.timestampWarned {
+			Error.Printf("Entry %s has no %s timestamp, entries without it are not tracked, so they may be "+
+				"printed twice in follow mode.\n", hit.Id, tail.queryDefinition.TimestampField)
+			tail.timestampWarned = true
+		}
+		return
+	}
+	if timeStamp != tail.lastTimeStamp {
+		tail.lastTimeStamp = timeStamp
+	}
+	tail.trackEntry(hit, entry, timeStamp)
+}
+
+// Drops entries that left the tailing window from the tracked ones and flushes the output after a page of results
+func (tail *Tail) finishResults() {
+	cutoffTime := tail.tailingWindowStart()
+	drainOldEntries(&tail.lastIDs, cutoffTime)
+	if capEntries(&tail.lastIDs, tail.maxDedupIDs) && !tail.dedupCapWarned {
+		Error.Printf("More than %d entries arrived within the %dms tailing window. IDs of the oldest ones are no "+
+			"longer tracked, so they may be printed twice. Raise --max-dedup-ids to avoid it.\n",
+			tail.maxDedupIDs, tailingTimeWindow)
+		tail.dedupCapWarned = true
+	}
+	tail.drainDisplayedVersions()
+	if err := tail.output.Flush(); err != nil {
+		Error.Fatalln("Failed writing output.", err)
+	}
+	for _, sink := range tail.sinks {
+		if err := sink.flush(); err != nil {
+			Error.Fatalln("Failed writing output.", err)
+		}
+	}
+	//fmt.Print("------------------------------------------------\n")
+	//Debugging IDs
+	//Info.Printf("CutOff time: %s", cutoffTime)
+	//Info.Printf("IDs: %v", tail.lastIDs)
+}
+
+func parseElasticTimeStamp(elTimeStamp string) time.Time {
+	timeStr, _ := time.Parse(dateFormatFull, elTimeStamp)
+	return timeStr
+}
+
+func formatElasticTimeStamp(timeStamp time.Time) string {
 	return timeStamp.Format(dateFormatFull)
 }
 
+// Formats the timestamp for queries, with nanosecond precision if the timestamp field has it
+func (tail *Tail) formatTimeStamp(timeStamp time.Time) string {
+	if tail.nanos {
+		return timeStamp.Format(dateFormatNanos)
+	}
+	return formatElasticTimeStamp(timeStamp)
+}
+
+// Returns the start of the tailing time window before the last timestamp. Entries from the window are fetched again
+// by follow queries (except those already displayed), as they may have been indexed late.
+func (tail *Tail) tailingWindowStart() string {
+	return tail.formatTimeStamp(parseElasticTimeStamp(tail.lastTimeStamp).Add(-tailingTimeWindow * time.Millisecond))
+}
+
+// Checks the mapping of the timestamp field, returns true if it's date_nanos in any of the indices. Mapping API may
+// not be allowed (e.g. through Kibana proxy), then --nanos has to be given explicitly.
+func (tail *Tail) detectDateNanos() bool {
+	mapping, err := tail.client.GetFieldMapping().
+		Index(tail.indices...).
+		Field(tail.queryDefinition.TimestampField).
+		Do(context.Background())
+	if err != nil {
+		Trace.Printf("Could not get mapping of %s field: %s", tail.queryDefinition.TimestampField, err)
+		return false
+	}
+	if isDateNanosMapping(mapping, tail.queryDefinition.TimestampField) {
+		Info.Printf("Field %s is date_nanos, using nanosecond precision.", tail.queryDefinition.TimestampField)
+		return true
+	}
+	return false
+}
+
+// Looks for date_nanos type of the field in get field mapping response:
+// {"index": {"mappings": {"field": {"mapping": {"leaf": {"type": "date_nanos"}}}}}}
+func isDateNanosMapping(mapping map[string]interface{}, field string) bool {
+	leaf := field[strings.LastIndex(field, ".")+1:]
+	for _, index := range mapping {
+		fieldMapping := nestedMap(index, "mappings", field, "mapping", leaf)
+		if fieldMapping != nil && fieldMapping["type"] == dateNanosType {
+			return true
+		}
+	}
+	return false
+}
+
+// Follows the keys through nested maps, returns nil if any of them is missing or not a map
+func nestedMap(value interface{}, keys ...string) map[string]interface{} {
+	current, _ := value.(map[string]interface{})
+	for _, key := range keys {
+		current, _ = current[key].(map[string]interface{})
+	}
+	return current
+}
+
+// Removes entries older than the cutoff timestamp. Entries are ordered by timestamp, so the old ones are at the start.
 func drainOldEntries(entries *[]displayedEntry, cutOffTimestamp string) {
 	var i int
-	for i = 0; i < len(*entries)-1 && (*entries)[i].timeStamp < cutOffTimestamp; i++ {
+	for i = 0; i < len(*entries) && (*entries)[i].isBefore(cutOffTimestamp); i++ {
+	}
+	*entries = (*entries)[i:]
+}
+
+// Remembers the identity of displayed entry, so it can be excluded from the next follow query. Identity is _id,
+// or value of --dedup-id-field if given.
+func (tail *Tail) trackEntry(hit *elastic.SearchHit, entry map[string]interface{}, timeStamp string) {
+	id := hit.Id
+	if tail.dedupIdField != "" {
+		var err error
+		id, err = EvaluateExpression(entry, tail.dedupIdField)
+		if err != nil {
+			Trace.Printf("Entry %s has no %s field, it can't be deduplicated.", hit.Id, tail.dedupIdField)
+			return
+		}
+	}
+	tail.lastIDs = append(tail.lastIDs, displayedEntry{timeStamp: timeStamp, id: id})
+	if tail.trackUpdates {
+		if tail.displayedVersion == nil {
+			tail.displayedVersion = map[string]documentVersion{}
+		}
+		tail.displayedVersion[id] = hitVersion(hit)
+	}
+}
+
+// Returns the version of the hit (zero if it wasn't requested)
+func hitVersion(hit *elastic.SearchHit) documentVersion {
+	var version documentVersion
+	if hit.SeqNo != nil && hit.PrimaryTerm != nil {
+		version = documentVersion{seqNo: *hit.SeqNo, primaryTerm: *hit.PrimaryTerm}
+	}
+	return version
+}
+
+// With --track-updates, displayed entries are not excluded from follow queries, so they are skipped here unless
+// the document was updated since it was displayed
+func (tail *Tail) isDisplayed(hit *elastic.SearchHit) bool {
+	if !tail.trackUpdates {
+		return false
+	}
+	version, ok := tail.displayedVersion[hit.Id]
+	return ok && version == hitVersion(hit)
+}
+
+// Forgets versions of the entries no longer in lastIDs
+func (tail *Tail) drainDisplayedVersions() {
+	if len(tail.displayedVersion) == 0 {
+		return
+	}
+	tracked := make(map[string]bool, len(tail.lastIDs))
+	for _, displayed := range tail.lastIDs {
+		tracked[displayed.id] = true
+	}
+	for id := range tail.displayedVersion {
+		if !tracked[id] {
+			delete(tail.displayedVersion, id)
+		}
+	}
+}
+
+// Evicts the oldest entries so that at most max remain (no limit if max is not positive). Returns true if any
+// entries were evicted.
+func capEntries(entries *[]displayedEntry, max int) bool {
+	if max <= 0 || len(*entries) <= max {
+		return false
+	}
+	*entries = (*entries)[len(*entries)-max:]
+	return true
+}
+
+// Parses the document source. Numbers are kept as json.Number, so big integers (e.g. 64-bit IDs) are not rounded
+// to float64 and render as they were stored.
+func parseEntry(source []byte) (map[string]interface{}, error) {
+	var entry map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Prints the hit, returning its entry and timestamp (empty if the entry has none)
+func (tail *Tail) processHit(hit *elastic.SearchHit) (map[string]interface{}, string) {
+	var entry map[string]interface{}
+	var err error
+	source := hit.Source
+	if tail.useFieldsAPI {
+		entry = fieldsEntry(hit.Fields)
+		source, _ = json.Marshal(entry)
+	} else {
+		entry, err = parseEntry(hit.Source)
+		if err != nil {
+			Error.Fatalln("Failed parsing ElasticSearch response.", err)
+		}
+		tail.addRuntimeFields(entry, hit)
+	}
+	//taken before the entry is changed for output, as renames may move the timestamp field
+	timeStamp, _ := entry[tail.queryDefinition.TimestampField].(string)
+
+	if len(tail.decoders) > 0 && !tail.raw {
+		decodeFields(entry, tail.decoders)
+	}
+	if len(tail.coercions) > 0 && !tail.raw {
+		coerceFields(entry, tail.coercions)
+	}
+	if len(tail.renames) > 0 {
+		renameFields(entry, tail.renames)
+		source, _ = json.Marshal(entry)
+	}
+
+	if tail.highlight != nil && !tail.ndjson && !tail.raw {
+		applyHighlights(entry, hit.Highlight)
+	}
+	if len(tail.unescape) > 0 && !tail.ndjson && !tail.raw {
+		unescapeFields(entry, tail.unescape)
+	}
+
+	var line string
+	if tail.ndjson {
+		ndjsonEntry, fields := tail.withMetadataFields(hit, entry)
+		line, err = formatNDJSON(ndjsonEntry, fields, !tail.sortFields)
+		if err != nil {
+			Error.Fatalln("Failed rendering entry as JSON.", err)
+		}
+	} else if tail.raw {
+		line = string(source)
+	} else if tail.smart {
+		line = tail.formatSmartResult(entry, source)
+	} else if tail.template != nil {
+		line, err = tail.template.render(entry)
+		if err != nil {
+			Error.Printf("Failed rendering entry %s with template: %s\n", hit.Id, err)
+			return entry, timeStamp
+		}
+	} else {
+		line = tail.formatResult(entry)
+	}
+	if tail.printLine(hit, line, entry) {
+		tail.writeToSinks(hit, entry, source)
+	}
+
+	return entry, timeStamp
+}
+
+// Prints the rendered line of the hit if it's selected by --grep/--grep-v and wasn't printed already. Entry is
+// only needed for coloring. Returns whether the line was printed.
+func (tail *Tail) printLine(hit *elastic.SearchHit, line string, entry map[string]interface{}) bool {
+	//ndjson lines are printed as they are, so they stay valid JSON
+	if tail.showIndex && !tail.ndjson {
+		line = "[" + hit.Index + "] " + line
+	}
+	if tail.isSelected(line) && !tail.isRepeated(line) && (tail.printedLines == nil || tail.printedLines.add(line)) {
+		if tail.color && !tail.ndjson {
+			line = colorizeLine(line, entry)
+		}
+		if !tail.ndjson {
+			line = tail.label + line
+		}
+		fmt.Fprintln(tail.output, line)
+		if tail.explain && hit.Explanation != nil && tail.explainedEntries < maxExplainedEntries {
+			writeExplanation(tail.output, hit.Explanation, 1)
+			tail.explainedEntries++
+		}
+		if tail.lineBuffered {
+			tail.output.Flush()
+		}
+		tail.printedEntries++
+		return true
+	}
+	return false
+}
+
+// Adds the index (--show-index) and the query name (--queries-file) of the hit to a copy of the entry, as ndjson
+// can't be prefixed with them like text lines. They are listed first if only some fields are output.
+func (tail *Tail) withMetadataFields(hit *elastic.SearchHit, entry map[string]interface{}) (map[string]interface{}, []string) {
+	metadata := map[string]interface{}{}
+	var keys []string
+	if tail.queryName != "" {
+		metadata["_query"] = tail.queryName
+		keys = append(keys, "_query")
+	}
+	if tail.showIndex {
+		metadata["_index"] = hit.Index
+		keys = append(keys, "_index")
+	}
+	if len(keys) == 0 {
+		return entry, tail.fields
+	}
+	for key, value := range entry {
+		metadata[key] = value
+	}
+	if len(tail.fields) == 0 {
+		return metadata, nil
+	}
+	return metadata, append(keys, tail.fields...)
+}
+
+// Returns true if the line repeats the previous one and should be suppressed (--uniq). Summary of the previous run
+// of repeated lines is printed before a different line.
+func (tail *Tail) isRepeated(line string) bool {
+	if tail.repeatedLines == nil {
+		return false
+	}
+	repeated, summary := tail.repeatedLines.add(line)
+	if summary != "" {
+		fmt.Fprintln(tail.output, summary)
+	}
+	return repeated
+}
+
+// Prints the summary of lines repeated at the end of the output
+func (tail *Tail) finishRepeatedLines() {
+	if tail.repeatedLines == nil {
+		return
+	}
+	if summary := tail.repeatedLines.end(); summary != "" {
+		fmt.Fprintln(tail.output, summary)
+		if err := tail.output.Flush(); err != nil {
+			Error.Fatalln("Failed writing output.", err)
+		}
+	}
+}
+
+// Closes the additional output sinks after the last entry was printed
+func (tail *Tail) closeSinks() {
+	for _, sink := range tail.sinks {
+		if err := sink.close(); err != nil {
+			Error.Fatalln("Failed writing output.", err)
+		}
+	}
+}
+
+// Writes the printed entry to additional output sinks
+func (tail *Tail) writeToSinks(hit *elastic.SearchHit, entry map[string]interface{}, source []byte) {
+	for _, sink := range tail.sinks {
+		if err := sink.write(hit, entry, source); err != nil {
+			Error.Fatalln("Failed writing output.", err)
+		}
+	}
+}
+
+// Raw dump in list-only mode prints sources as they are. Entries don't have to be parsed, as timestamps are only
+// tracked when following, unless fields are renamed, read from the fields API or lines colored by level.
+func (tail *Tail) isRawFastPath() bool {
+	return tail.raw && !tail.follow && len(tail.renames) == 0 && !tail.useFieldsAPI && !tail.color
+}
+
+// Adds values of runtime fields returned with the hit to the entry so they can be used in format
+func (tail *Tail) addRuntimeFields(entry map[string]interface{}, hit *elastic.SearchHit) {
+	for _, name := range tail.runtimeFields {
+		if value, ok := unwrapFieldValues(hit.Fields[name]); ok {
+			entry[name] = value
+		}
+	}
+}
+
+// Builds the entry from values returned by the fields API. Fields are keyed by their full (dotted) name, which
+// format expressions resolve the same way as nested source fields.
+func fieldsEntry(fields map[string]interface{}) map[string]interface{} {
+	entry := make(map[string]interface{}, len(fields))
+	for name, values := range fields {
+		if value, ok := unwrapFieldValues(values); ok {
+			entry[name] = value
+		}
+	}
+	return entry
+}
+
+// Marks of the terms matched by the query in highlighted fragments
+const (
+	highlightPreTag  = ">>"
+	highlightPostTag = "<<"
+	//reverse video is turned off explicitly, so the rest of the line keeps its level color
+	ansiHighlightPreTag  = "\033[7m"
+	ansiHighlightPostTag = "\033[27m"
+)
+
+// Creates highlighting of the fields, marking the matched terms with reverse video when output is colored
+func newHighlight(fields []string, color bool) *elastic.Highlight {
+	preTag, postTag := highlightPreTag, highlightPostTag
+	if color {
+		preTag, postTag = ansiHighlightPreTag, ansiHighlightPostTag
+	}
+	highlight := elastic.NewHighlight().PreTags(preTag).PostTags(postTag)
+	for _, field := range fields {
+		highlight = highlight.Fields(elastic.NewHighlighterField(field))
+	}
+	return highlight
+}
+
+// Replaces the values of highlighted fields with their fragments. Fragments are keyed by the full (dotted) field
+// name, which format expressions resolve before nested fields.
+func applyHighlights(entry map[string]interface{}, highlight elastic.SearchHitHighlight) {
+	for field, fragments := range highlight {
+		if len(fragments) > 0 {
+			entry[field] = strings.Join(fragments, " ... ")
+		}
+	}
+}
+
+// Field values are always returned as arrays, single values are unwrapped so they render the same as source values
+func unwrapFieldValues(values interface{}) (interface{}, bool) {
+	list, ok := values.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, false
+	}
+	if len(list) == 1 {
+		return list[0], true
+	}
+	return list, true
+}
+
+type fieldRename struct {
+	from string
+	to   string
+}
+
+// Moves values of renamed fields to their new (top level) names. Fields missing in the entry are skipped.
+func renameFields(entry map[string]interface{}, renames []fieldRename) {
+	for _, rename := range renames {
+		if value, ok := takeField(entry, rename.from); ok {
+			entry[rename.to] = value
+		}
+	}
+}
+
+// Removes the field given in dot syntax (see EvaluateExpression) from the model and returns its value
+func takeField(model map[string]interface{}, fieldExpression string) (interface{}, bool) {
+	parent, key, ok := lookupField(model, fieldExpression)
+	if !ok {
+		return nil, false
+	}
+	value := parent[key]
+	delete(parent, key)
+	return value, true
+}
+
+// Finds the map holding the field given in dot syntax (see EvaluateExpression) and the field's key in it
+func lookupField(model map[string]interface{}, fieldExpression string) (map[string]interface{}, string, bool) {
+	if _, ok := model[fieldExpression]; ok {
+		return model, fieldExpression, true
+	}
+	parts := strings.SplitN(fieldExpression, ".", 2)
+	if len(parts) < 2 {
+		return nil, "", false
+	}
+	child, ok := model[parts[0]].(map[string]interface{})
+	if !ok {
+		return nil, "", false
+	}
+	return lookupField(child, parts[1])
+}
+
+// Indentation of continuation lines of unescaped multiline fields
+const multilineIndent = "    "
+
+// Renders escaped (\n, \t) and real line breaks in the string fields across indented lines, so that e.g.
+// stack traces are readable
+func unescapeFields(entry map[string]interface{}, fields []string) {
+	for _, field := range fields {
+		parent, key, ok := lookupField(entry, field)
+		if !ok {
+			continue
+		}
+		if value, ok := parent[key].(string); ok {
+			parent[key] = unescapeMultiline(value)
+		}
+	}
+}
+
+func unescapeMultiline(value string) string {
+	value = strings.NewReplacer("\\r\\n", "\n", "\\n", "\n", "\\t", "\t", "\r\n", "\n").Replace(value)
+	value = strings.TrimRight(value, "\n")
+	return strings.Replace(value, "\n", "\n"+multilineIndent, -1)
+}
+
+// Regexp for parsing out format fields, either %field.name or %{expression} (e.g. JSONPath)
+var formatRegexp = regexp.MustCompile("%\\{[^}]+\\}|%[A-Za-z0-9@_.-]+")
+
+// Format result according to format
+func (tail *Tail) formatResult(entry map[string]interface{}) string {
+	fields := formatRegexp.FindAllString(tail.queryDefinition.Format, -1)
+	result := tail.queryDefinition.Format
+	for _, f := range fields {
+		value, err := evaluateFormatField(entry, f)
+		if err != nil {
+			value = tail.missingField
+		} else if tail.timeLayout != "" && formatFieldExpression(f) == tail.queryDefinition.TimestampField {
+			value = formatDisplayTimeStamp(value, tail.timeLayout)
+		}
+		result = strings.Replace(result, f, value, -1)
+	}
+	return result
+}
+
+// Evaluates format field (%field.name or %{expression}) on the entry. Expressions starting with $ are evaluated
+// as JSONPath, others using dot syntax.
+func evaluateFormatField(entry map[string]interface{}, field string) (string, error) {
+	expression := formatFieldExpression(field)
+	if strings.HasPrefix(expression, "$") {
+		return EvaluateJSONPath(entry, expression)
+	}
+	return EvaluateExpression(entry, expression)
+}
+
+// Returns the expression of format field, e.g. field.name for both %field.name and %{field.name}
+func formatFieldExpression(field string) string {
+	expression := field[1:]
+	if strings.HasPrefix(expression, "{") {
+		expression = expression[1 : len(expression)-1]
+	}
+	return expression
+}
+
+// Returns the field paths referenced in format, or nil if format uses a JSONPath expression (whose fields can't
+// be determined reliably)
+func formatFields(format string) []string {
+	var fields []string
+	for _, field := range formatRegexp.FindAllString(format, -1) {
+		expression := formatFieldExpression(field)
+		if strings.HasPrefix(expression, "$") {
+			return nil
+		}
+		fields = append(fields, expression)
+	}
+	return fields
+}
+
+// Source fields needed to render entries with the format, along with fields used for deduplication, decoding,
+// unescaping and highlighting. Renamed fields are fetched by their original name, runtime fields are skipped as they
+// are not part of the source. Falls back to the whole source (nil) if the format references no fields.
+func (tail *Tail) autoSourceFields() []string {
+	fields := formatFields(tail.queryDefinition.Format)
+	if len(fields) == 0 {
+		return nil
+	}
+	sourceFields := []string{tail.queryDefinition.TimestampField}
+	if tail.color {
+		sourceFields = append(sourceFields, levelFields...)
+	}
+	for _, field := range fields {
+		for _, rename := range tail.renames {
+			if rename.to == field {
+				field = rename.from
+			}
+		}
+		isRuntimeField := false
+		for _, runtimeField := range tail.runtimeFields {
+			isRuntimeField = isRuntimeField || runtimeField == field
+		}
+		if !isRuntimeField {
+			sourceFields = append(sourceFields, field)
+		}
 	}
-	*entries = (*entries)[i:]
+	if tail.dedupIdField != "" {
+		sourceFields = append(sourceFields, tail.dedupIdField)
+	}
+	for _, decoder := range tail.decoders {
+		sourceFields = append(sourceFields, decoder.field)
+	}
+	sourceFields = append(sourceFields, tail.unescape...)
+	return append(sourceFields, tail.highlightFields...)
 }
 
-func (tail *Tail) processHit(hit *elastic.SearchHit) map[string]interface{} {
-	var entry map[string]interface{}
-	err := json.Unmarshal(hit.Source, &entry)
-	if err != nil {
-		Error.Fatalln("Failed parsing ElasticSearch response.", err)
+// Zero-config format: message field, prefixed with timestamp if present. Falls back to raw source if the entry
+// has no message field
+func (tail *Tail) formatSmartResult(entry map[string]interface{}, source []byte) string {
+	message, ok := entry[smartMessageField]
+	if !ok || message == nil {
+		return string(source)
 	}
-
-	if tail.raw {
-		fmt.Println(string(hit.Source))
-	} else {
-		tail.printResult(entry)
+	result := fmt.Sprintf("%v", message)
+	if timeStamp, ok := entry[tail.queryDefinition.TimestampField]; ok && timeStamp != nil {
+		timeStampStr := fmt.Sprintf("%v", timeStamp)
+		if tail.timeLayout != "" {
+			timeStampStr = formatDisplayTimeStamp(timeStampStr, tail.timeLayout)
+		}
+		result = fmt.Sprintf("%s :: %s", timeStampStr, result)
 	}
-
-	return entry
+	return result
 }
 
-// Regexp for parsing out format fields
-var formatRegexp = regexp.MustCompile("%[A-Za-z0-9@_.-]+")
+// Writes the scoring explanation tree, indenting details under their parent
+func writeExplanation(writer io.Writer, explanation *elastic.SearchExplanation, depth int) {
+	fmt.Fprintf(writer, "%s%g = %s\n", strings.Repeat("  ", depth), explanation.Value, explanation.Description)
+	for i := range explanation.Details {
+		writeExplanation(writer, &explanation.Details[i], depth+1)
+	}
+}
 
-// Print result according to format
-func (tail *Tail) printResult(entry map[string]interface{}) {
-	fields := formatRegexp.FindAllString(tail.queryDefinition.Format, -1)
-	result := tail.queryDefinition.Format
-	for _, f := range fields {
-		value, _ := EvaluateExpression(entry, f[1:])
-		result = strings.Replace(result, f, value, -1)
+// Checks the rendered output line against --grep and --grep-v regular expressions
+func (tail *Tail) isSelected(line string) bool {
+	if tail.grep != nil && !tail.grep.MatchString(line) {
+		return false
 	}
-	fmt.Println(result)
+	if tail.grepInvert != nil && tail.grepInvert.MatchString(line) {
+		return false
+	}
+	return true
 }
 
 func (tail *Tail) buildSearchQuery() elastic.Query {
 	var query elastic.Query
 	if len(tail.queryDefinition.Terms) > 0 {
 		result := strings.Join(tail.queryDefinition.Terms, " ")
+		if tail.escape {
+			result = escapeQueryString(result)
+		}
 		Trace.Printf("Running query string query: %s", result)
 		query = elastic.NewQueryStringQuery(result)
-	} else {
+	} else if len(tail.termQueries) == 0 && len(tail.queryDefinition.AnyTerms) == 0 {
 		Trace.Print("Running query match all query.")
 		query = elastic.NewMatchAllQuery()
 	}
 
+	//clauses matched by --wildcard, --fuzzy and --phrase are ANDed with the query string
+	clauses := []elastic.Query{}
+	if query != nil {
+		clauses = append(clauses, query)
+	}
+	clauses = append(clauses, tail.termQueries...)
+	if len(tail.queryDefinition.AnyTerms) > 0 {
+		clauses = append(clauses, tail.buildAnyQuery())
+	}
+	if len(clauses) > 1 {
+		query = elastic.NewBoolQuery().Must(clauses...)
+	} else {
+		query = clauses[0]
+	}
+
+	filters := []elastic.Query{}
 	if tail.queryDefinition.IsDateTimeFiltered() {
 		// we have date filtering turned on, apply filter
-		filter := tail.buildDateTimeRangeQuery()
-		query = elastic.NewBoolQuery().Filter(query, filter)
+		filters = append(filters, tail.buildDateTimeRangeQuery())
+	}
+	if tail.queryDefinition.TraceId != "" {
+		Trace.Printf("Trace filter - %s: %s", tail.queryDefinition.TraceField, tail.queryDefinition.TraceId)
+		filters = append(filters, elastic.NewTermQuery(tail.queryDefinition.TraceField, tail.queryDefinition.TraceId))
+	}
+	filters = append(filters, tail.buildKubernetesFilters()...)
+	filters = append(filters, tail.importedFilters...)
+	if tail.excludedWindow != nil {
+		Trace.Printf("Excluding entries between %s UTC", tail.queryDefinition.ExcludeTime)
+		filters = append(filters, tail.excludedWindow.buildFilter(tail.queryDefinition.TimestampField))
+	}
+	if len(filters) > 0 && tail.queryDefinition.MinScore > 0 {
+		//query has to stay in query context, otherwise it doesn't contribute to the score
+		query = elastic.NewBoolQuery().Must(clauses...).Filter(filters...)
+	} else if len(filters) > 0 {
+		query = elastic.NewBoolQuery().Filter(append(clauses, filters...)...)
+	}
+	if tail.queryDefinition.Sample > 0 && tail.queryDefinition.Sample < 100 {
+		//random score is uniformly distributed in [0, 1), so min score of 1-p keeps approximately p of entries
+		Trace.Printf("Sampling %g%% of entries", tail.queryDefinition.Sample)
+		query = elastic.NewFunctionScoreQuery().
+			Query(query).
+			AddScoreFunc(elastic.NewRandomFunction()).
+			BoostMode("replace").
+			MinScore(1 - tail.queryDefinition.Sample/100)
 	}
 	return query
 }
 
+// Lucene query string reserved characters which are escaped with backslash
+var queryStringReserved = "+-=&|!(){}[]^\"~*?:\\/"
+
+// Escapes Lucene query string reserved characters. < and > can not be escaped so they are removed.
+func escapeQueryString(query string) string {
+	var escaped strings.Builder
+	for _, c := range query {
+		if c == '<' || c == '>' {
+			continue
+		}
+		if strings.ContainsRune(queryStringReserved, c) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
+
 //Builds range filter on timestamp field. You should only call this if start or end date times are defined
 //in query definition
 func (tail *Tail) buildDateTimeRangeQuery() *elastic.RangeQuery {
@@ -391,17 +1881,31 @@ func (tail *Tail) buildDateTimeRangeQuery() *elastic.RangeQuery {
 }
 
 func (tail *Tail) buildTimestampFilteredQuery() elastic.Query {
-	timeStamp := formatElasticTimeStamp(parseElasticTimeStamp(tail.lastTimeStamp).Add(-tailingTimeWindow * time.Millisecond))
+	timeStamp := tail.tailingWindowStart()
 
 	timeStampFilter := elastic.NewRangeQuery(tail.queryDefinition.TimestampField).
 		Gte(timeStamp)
 
-	idsToFilter := make([]string, len(tail.lastIDs))
-	for i := range tail.lastIDs {
-		idsToFilter[i] = tail.lastIDs[i].id
+	var displayedFilter elastic.Query
+	if tail.dedupIdField != "" {
+		idsToFilter := make([]interface{}, len(tail.lastIDs))
+		for i := range tail.lastIDs {
+			idsToFilter[i] = tail.lastIDs[i].id
+		}
+		displayedFilter = elastic.NewTermsQuery(tail.dedupIdField, idsToFilter...)
+	} else {
+		idsToFilter := make([]string, len(tail.lastIDs))
+		for i := range tail.lastIDs {
+			idsToFilter[i] = tail.lastIDs[i].id
+		}
+		displayedFilter = elastic.NewIdsQuery().Ids(idsToFilter...)
 	}
 
-	filter := elastic.NewBoolQuery().Filter(timeStampFilter).MustNot(elastic.NewIdsQuery().Ids(idsToFilter...))
+	filter := elastic.NewBoolQuery().Filter(timeStampFilter)
+	if !tail.trackUpdates {
+		//with --track-updates, updated versions of displayed entries have to be fetched again
+		filter = filter.MustNot(displayedFilter)
+	}
 	query := elastic.NewBoolQuery().Filter(tail.buildSearchQuery(), filter)
 	return query
 }
@@ -438,19 +1942,75 @@ func findIndicesForDateRange(indices []string, indexPattern string, startDate st
 	return result
 }
 
+// Placeholders in index pattern, e.g. {env} in app-{env}-*
+var indexPatternPlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ResolveIndexPattern substitutes placeholders in index pattern, so one saved pattern works across environments.
+// {env} is replaced with --env (or ELKTAIL_ENV), other {NAME} placeholders with NAME environment variable.
+// {now} is left as it is, as it's part of date math index names (e.g. <app-{env}-{now}>).
+func ResolveIndexPattern(indexPattern string, env string, lookupEnv func(string) (string, bool)) (string, error) {
+	var err error
+	resolved := indexPatternPlaceholder.ReplaceAllStringFunc(indexPattern, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if name == "now" {
+			return placeholder
+		}
+		value, found, source := env, env != "", "--env"
+		if name != "env" {
+			value, found = lookupEnv(name)
+			source = name + " environment variable"
+		}
+		if !found && err == nil {
+			err = fmt.Errorf("placeholder %s in %s has no value, set %s", placeholder, indexPattern, source)
+		}
+		return value
+	})
+	return resolved, err
+}
+
+// Anchors the index pattern regular expression at the start of index names, so that e.g. app doesn't select
+// happ-logs-2023. Pattern is not anchored at the end, as patterns like filebeat-* are meant as prefixes.
+func anchorIndexPattern(indexPattern string) string {
+	return "^(?:" + indexPattern + ")"
+}
+
+// Checks if index is an elasticsearch date math index name (e.g. <logstash-{now/d}>)
+func isDateMathIndex(index string) bool {
+	return strings.HasPrefix(index, "<") && strings.HasSuffix(index, ">")
+}
+
 func findLastIndex(indices []string, indexPattern string) string {
-	var lastIdx string
+	ranked := rankIndices(indices, indexPattern)
+	if len(ranked) == 0 {
+		return ""
+	}
+	return ranked[0]
+}
+
+// Returns indices matching the pattern, latest first. Index names end with their date or rollover generation, so
+// the latest index has the greatest name.
+func rankIndices(indices []string, indexPattern string) []string {
+	var ranked []string
 	for _, idx := range indices {
 		matched, _ := regexp.MatchString(indexPattern, idx)
 		if matched {
-			if &lastIdx == nil {
-				lastIdx = idx
-			} else if idx > lastIdx {
-				lastIdx = idx
-			}
+			ranked = append(ranked, idx)
 		}
 	}
-	return lastIdx
+	sort.Sort(sort.Reverse(sort.StringSlice(ranked)))
+	return ranked
+}
+
+// Returns the index offset generations before the latest one matching the pattern (0 is the latest)
+func findIndexByOffset(indices []string, indexPattern string, offset int) (string, error) {
+	if offset < 0 {
+		return "", fmt.Errorf("offset %d must not be negative", offset)
+	}
+	ranked := rankIndices(indices, indexPattern)
+	if offset >= len(ranked) {
+		return "", fmt.Errorf("offset %d is out of range, %d indices match %s", offset, len(ranked), indexPattern)
+	}
+	return ranked[offset], nil
 }
 
 func main() {
@@ -463,8 +2023,21 @@ func main() {
 	app.Version = VERSION
 	app.ArgsUsage = "[query-string]\n   Options marked with (*) are saved between invocations of the command. Each time you specify an option marked with (*) previously stored settings are erased."
 	app.Flags = config.Flags()
+	app.Commands = []cli.Command{CompletionCommand(app.Flags)}
 	app.Action = func(c *cli.Context) {
 		config.SearchTarget.ExtraHeaders = c.StringSlice("header")
+		config.QueryParams = c.StringSlice("query-param")
+		config.RuntimeFields = c.StringSlice("runtime-field")
+		config.Renames = c.StringSlice("rename")
+		config.Decode = c.StringSlice("decode")
+		config.Coerce = c.StringSlice("coerce")
+		config.Fields = c.StringSlice("fields")
+		config.Unescape = c.StringSlice("unescape")
+		config.HighlightFields = c.StringSlice("highlight-field")
+		config.QueryDefinition.Wildcards = c.StringSlice("wildcard")
+		config.QueryDefinition.Fuzzies = c.StringSlice("fuzzy")
+		config.QueryDefinition.AnyTerms = c.StringSlice("any")
+		config.QueryDefinition.Phrases = c.StringSlice("phrase")
 
 		if c.IsSet("help") {
 			cli.ShowAppHelp(c)
@@ -478,10 +2051,105 @@ func main() {
 			InitLogging(ioutil.Discard, ioutil.Discard, os.Stderr, false)
 		}
 
+		if config.QueryDefinition.Since > 0 {
+			if config.QueryDefinition.AfterDateTime != "" {
+				Error.Fatalln("Options --since and -a are mutually exclusive.")
+			}
+			config.QueryDefinition.AfterDateTime = formatElasticTimeStamp(time.Now().UTC().Add(-config.QueryDefinition.Since))
+			Info.Printf("Listing results since %s\n", config.QueryDefinition.AfterDateTime)
+		}
+
+		if config.QueryDefinition.Sample < 0 || config.QueryDefinition.Sample > 100 {
+			Error.Fatalln("Option --sample must be a percentage between 0 and 100.")
+		}
+
+		if config.IncludeFrozen {
+			Error.Printf("Searching frozen indices, queries may be slow.\n")
+		}
+
+		if config.Interactive && config.PasswordStdin {
+			Error.Fatalln("Options --interactive and --password-stdin are mutually exclusive.")
+		}
+
+		if config.PollOnDemand && config.PasswordStdin {
+			Error.Fatalln("Options --poll-on-demand and --password-stdin are mutually exclusive.")
+		}
+
+		if config.View && (config.PollOnDemand || config.PasswordStdin) {
+			Error.Fatalln("Option --view reads keys from stdin, it can't be used with --poll-on-demand or --password-stdin.")
+		}
+
+		if config.View && config.ViewLines < 1 {
+			Error.Fatalln("Option --view-lines must be positive.")
+		}
+
+		if config.QueryDefinition.MinScore > 0 && config.QueryDefinition.Sample > 0 {
+			Error.Fatalln("Options --min-score and --sample are mutually exclusive.")
+		}
+
+		if config.QueryDefinition.TraceId != "" {
+			//following logs of a trace as they arrive is the point of tracing by id
+			config.Follow = true
+		}
+
+		if config.AfterId != "" {
+			if config.TailOnly {
+				Error.Fatalln("Options --after-id and --tail-only are mutually exclusive.")
+			}
+			config.Follow = true
+		}
+
+		if config.TerminateAfter < 0 {
+			Error.Fatalln("Option --terminate-after must not be negative.")
+		}
+
+		if config.Heartbeat < 0 {
+			Error.Fatalln("Option --heartbeat must not be negative.")
+		}
+
+		if config.StatsInterval < 0 {
+			Error.Fatalln("Option --stats-interval must not be negative.")
+		}
+
+		if config.MaxIterations < 0 {
+			Error.Fatalln("Option --max-iterations must not be negative.")
+		}
+
+		if config.TerminateAfter > 0 && !config.IsListOnly() {
+			//shards stop collecting in index order, so entries arriving in between polls could be skipped
+			Error.Fatalln("Option --terminate-after can only be used in list-only mode.")
+		}
+
+		if config.Sort != "" && (!config.IsListOnly() || config.Concurrency > 1) {
+			//follow mode and merging of per-index results rely on entries being sorted by timestamp
+			Error.Fatalln("Option --sort can only be used in list-only mode and without --concurrency.")
+		}
+
+		if config.TrackUpdates && config.DedupIdField != "" {
+			Error.Fatalln("Options --track-updates and --dedup-id-field are mutually exclusive.")
+		}
+
+		if config.IndexOffset < 0 {
+			Error.Fatalln("Option --index-offset must not be negative.")
+		}
+
+		if config.Unordered && (config.Export == "" || config.Follow) {
+			//follow mode relies on entries being ordered by timestamp
+			Error.Fatalln("Option --unordered can only be used with --export and not in follow mode.")
+		}
+
+		if config.ReindexFormat && config.Export == "" {
+			Error.Fatalln("Option --reindex-format can only be used with --export.")
+		}
+
+		if err := configuration.ValidateProfileName(config.Profile); err != nil {
+			Error.Fatalln("Invalid --profile.", err)
+		}
+
 		if !configuration.IsConfigRelevantFlagSet(c) {
-			loadedConfig, err := configuration.LoadDefault()
+			loadedConfig, err := configuration.LoadProfile(config.Profile)
 			if err != nil {
-				Info.Printf("Failed to find or open previous default configuration: %s\n", err)
+				Info.Printf("Failed to find or open previous configuration of profile '%s': %s\n", config.Profile, err)
 			} else {
 				Info.Printf("Loaded previous config and connecting to host %s.\n", loadedConfig.SearchTarget.Url)
 				loadedConfig.CopyConfigRelevantSettingsTo(config)
@@ -498,6 +2166,16 @@ func main() {
 			}
 		}
 
+		if config.Preset != "" {
+			format, err := configuration.FormatPreset(config.Preset)
+			if err != nil {
+				Error.Fatalln("Invalid --preset.", err)
+			}
+			if !c.IsSet("l") {
+				config.QueryDefinition.Format = format
+			}
+		}
+
 		if config.User != "" {
 			credentials := strings.Split(config.User, ":")
 			config.User = credentials[0]
@@ -508,8 +2186,23 @@ func main() {
 			//config.Password = readPasswd()
 		}
 
+		if config.PasswordFile != "" {
+			password, err := readPasswordFile(config.PasswordFile)
+			if err != nil {
+				Error.Fatalf("Failed to read password from file %s: %s\n", config.PasswordFile, err)
+			}
+			config.Password = password
+		} else if config.PasswordStdin {
+			password, err := readSecret(os.Stdin)
+			if err != nil {
+				Error.Fatalf("Failed to read password from stdin: %s\n", err)
+			}
+			config.Password = password
+		}
+
 		//reset TunnelUrl to nothing, we'll point to the tunnel if we actually manage to create it
 		config.SearchTarget.TunnelUrl = ""
+		var tunnel *SSHTunnel
 		if config.SSHTunnelParams != "" {
 			//We need to start ssh tunnel and make el client connect to local port at localhost in order to pass
 			//traffic through the tunnel
@@ -519,10 +2212,25 @@ func main() {
 			}
 			Trace.Printf("SSHTunnel remote host: %s\n", elurl.Host)
 
-			tunnel := NewSSHTunnelFromHostStrings(config.SSHTunnelParams, elurl.Host)
+			tunnel = NewSSHTunnelFromHostStrings(config.SSHTunnelParams, elurl.Host)
+			tunnel.KeepAlive = config.SSHKeepAlive
+			if config.OnReconnect != "" {
+				tunnel.OnReconnect = func(attempts int, err error) {
+					runReconnectHook(config.OnReconnect, tunnel.Server.String(), attempts, err)
+				}
+			}
+			if config.SSHKey != "" {
+				keyAuth, err := PrivateKeyFile(config.SSHKey)
+				if err != nil {
+					Error.Fatalf("Failed to load ssh key %s: %s\n", config.SSHKey, err)
+				}
+				tunnel.Config.Auth = append([]ssh.AuthMethod{keyAuth}, tunnel.Config.Auth...)
+			}
 			//Using the TunnelUrl configuration param, we will signify the client to connect to tunnel
 			config.SearchTarget.TunnelUrl = fmt.Sprintf("http://localhost:%d", tunnel.Local.Port)
-
+		}
+		//the connection check establishes the tunnel itself, stage by stage
+		if tunnel != nil && !config.Check {
 			Info.Printf("Starting SSH tunnel %d:%s@%s:%d to %s:%d", tunnel.Local.Port, tunnel.Config.User,
 				tunnel.Server.Host, tunnel.Server.Port, tunnel.Remote.Host, tunnel.Remote.Port)
 			go tunnel.Start()
@@ -533,6 +2241,18 @@ func main() {
 		var configToSave *configuration.Configuration
 
 		args := c.Args()
+		if len(args) == 1 && args[0] == "-" {
+			if config.PasswordStdin || config.PollOnDemand || config.Interactive || config.View {
+				Error.Fatalln("Query can't be read from stdin together with --password-stdin, --poll-on-demand, " +
+					"--interactive or --view, which read stdin as well.")
+			}
+			query, err := readQuery(os.Stdin)
+			if err != nil {
+				Error.Fatalln("Failed reading query from stdin.", err)
+			}
+			Info.Printf("Read query from stdin: %s\n", query)
+			args = cli.Args{query}
+		}
 
 		if config.SaveQuery {
 			if args.Present() {
@@ -547,22 +2267,174 @@ func main() {
 			Trace.Printf("Not saving query terms. Total terms: %d\n", len(config.QueryDefinition.Terms))
 			configToSave = config.Copy()
 			if args.Present() {
-				if len(config.QueryDefinition.Terms) > 1 {
-					config.QueryDefinition.Terms = append(config.QueryDefinition.Terms, "AND")
-					config.QueryDefinition.Terms = append(config.QueryDefinition.Terms, args...)
+				terms, err := combineTerms(config.QueryDefinition.Terms, args, config.Combine)
+				if err != nil {
+					Error.Fatalf("Invalid --combine: %s", err)
+				}
+				config.QueryDefinition.Terms = terms
+			}
+		}
+
+		if config.SavedSearch != "" {
+			savedSearch, err := FetchSavedSearch(config, config.SavedSearch)
+			if err != nil {
+				Error.Fatalf("Failed to fetch saved search %s: %s\n", config.SavedSearch, err)
+			}
+			Info.Printf("Using saved search '%s' on index pattern %s: %s\n", savedSearch.Title, savedSearch.IndexPattern, savedSearch.Query)
+			if savedSearch.Language == "kuery" {
+				Error.Printf("Saved search uses KQL, which is run as a query string query and may not work as expected.\n")
+			}
+			config.SearchTarget.IndexPattern = savedSearch.IndexPattern
+			if savedSearch.Query != "" {
+				if len(config.QueryDefinition.Terms) > 0 {
+					config.QueryDefinition.Terms = append([]string{"(" + savedSearch.Query + ")", "AND"}, config.QueryDefinition.Terms...)
 				} else {
-					config.QueryDefinition.Terms = []string{args.First()}
-					config.QueryDefinition.Terms = append(config.QueryDefinition.Terms, args.Tail()...)
+					config.QueryDefinition.Terms = []string{savedSearch.Query}
 				}
 			}
 		}
 
+		if config.QueryDefinition.ImportQuery != "" {
+			imported, err := LoadKibanaQuery(config.QueryDefinition.ImportQuery)
+			if err != nil {
+				Error.Fatalln("Failed reading --import-query.", err)
+			}
+			Info.Printf("Imported query '%s' with %d filters\n", imported.Query, len(imported.Filters))
+			if imported.Language == "kuery" {
+				Error.Printf("Imported query uses KQL, which is run as a query string query and may not work as expected.\n")
+			}
+			if imported.Query != "" {
+				config.QueryDefinition.Terms = andTerms(config.QueryDefinition.Terms, []string{imported.Query})
+			}
+		}
+
+		if config.QueryDefinition.TermsFile != "" {
+			terms, err := ReadTermsFile(config.QueryDefinition.TermsFile)
+			if err != nil {
+				Error.Fatalln("Failed reading --terms-file.", err)
+			}
+			Info.Printf("Read %d terms from %s\n", len(terms), config.QueryDefinition.TermsFile)
+			if config.QueryDefinition.TermsFileAny {
+				config.QueryDefinition.AnyTerms = append(config.QueryDefinition.AnyTerms, terms...)
+			} else {
+				config.QueryDefinition.Terms = andTerms(config.QueryDefinition.Terms, terms)
+			}
+		} else if config.QueryDefinition.TermsFileAny {
+			Error.Fatalln("Option --terms-file-any can only be used with --terms-file.")
+		}
+
+		if config.QueryDefinition.MinScore > 0 && len(config.QueryDefinition.Terms) == 0 &&
+			len(config.QueryDefinition.AnyTerms) == 0 {
+			Error.Printf("Option --min-score used without query terms. All entries score the same, so it either " +
+				"filters out all or none of them.\n")
+		}
+
+		if config.Check {
+			if !RunConnectionCheck(config, tunnel, os.Stdout) {
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+
+		if config.QueriesFile != "" {
+			if config.AfterId != "" || config.PollOnDemand || config.View || config.Interactive || config.Sort != "" ||
+				config.Concurrency > 1 || config.Histogram != "" || config.Count || config.Ends > 0 ||
+				config.Export != "" || config.Id != "" || config.ListIndices {
+				Error.Fatalln("Option --queries-file can only be used for listing and following entries.")
+			}
+			queries, err := LoadNamedQueries(config.QueriesFile)
+			if err != nil {
+				Error.Fatalln("Failed reading --queries-file.", err)
+			}
+			multi := NewMultiTail(config, queries)
+			configToSave.SaveProfile(config.Profile)
+			multi.Start(!config.IsListOnly(), config.InitialEntries)
+			if config.FailOnEmpty && multi.PrintedEntries() == 0 || config.FailOnFound && multi.PrintedEntries() > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+
 		tail := NewTail(config)
 
 		//If we don't exit here we can save the defaults
-		configToSave.SaveDefault()
+		configToSave.SaveProfile(config.Profile)
+
+		if config.VersionCheck {
+			tail.CheckVersion()
+		}
+
+		if config.Interactive {
+			if err := tail.PickFields(os.Stdin, os.Stderr); err != nil {
+				Error.Fatalln("Failed picking fields.", err)
+			}
+		}
+
+		if config.ListIndices {
+			if err := tail.ListIndices(os.Stdout, config.Verbose || config.MoreVerbose); err != nil {
+				Error.Fatalln("Failed listing indices.", err)
+			}
+			return
+		}
+
+		if config.Id != "" {
+			if err := tail.PrintDocument(config.Index, config.Id); err != nil {
+				Error.Fatalln("Error fetching document.", err)
+			}
+			return
+		}
+
+		if config.Histogram != "" {
+			if err := tail.PrintHistogram(config.Histogram); err != nil {
+				Error.Fatalln("Error in executing histogram query.", describeSearchError(err))
+			}
+			return
+		}
+
+		if config.Count {
+			count, err := tail.PrintCount(os.Stdout)
+			if err != nil {
+				Error.Fatalln("Error in executing count query.", describeSearchError(err))
+			}
+			if config.FailOnEmpty && count == 0 || config.FailOnFound && count > 0 {
+				os.Exit(1)
+			}
+			return
+		}
+
+		if config.Ends > 0 {
+			if err := tail.PrintEnds(config.Ends); err != nil {
+				Error.Fatalln("Error in executing search query.", describeSearchError(err))
+			}
+			return
+		}
+
+		if config.Export != "" {
+			if err := tail.Export(config.Export); err != nil {
+				Error.Fatalln("Error exporting entries.", err)
+			}
+			return
+		}
+
+		if config.View {
+			if err := tail.StartRollingView(config.ViewLines); err != nil {
+				Error.Fatalln("Failed starting rolling view.", err)
+			}
+		}
 
 		tail.Start(!config.IsListOnly(), config.InitialEntries)
+
+		if config.View {
+			//listed entries stay in the view until user quits it
+			select {}
+		}
+
+		if config.FailOnEmpty && tail.printedEntries == 0 {
+			os.Exit(1)
+		}
+		if config.FailOnFound && tail.printedEntries > 0 {
+			os.Exit(1)
+		}
 	}
 
 	app.Run(os.Args)
@@ -587,6 +2459,25 @@ func readPasswd() string {
 	return string(bytePassword)
 }
 
+// Read password from the given file
+func readPasswordFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readSecret(file)
+}
+
+// Read a secret (e.g. password) from reader, trimming the trailing newline
+func readSecret(reader io.Reader) (string, error) {
+	secret, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
 // EvaluateExpression Expression evaluation function. It uses map as a model and evaluates expression given as
 // the parameter using dot syntax:
 // "foo" evaluates to model[foo]
@@ -595,6 +2486,9 @@ func readPasswd() string {
 // an error.
 func EvaluateExpression(model interface{}, fieldExpression string) (string, error) {
 	if fieldExpression == "" {
+		if number, ok := model.(json.Number); ok {
+			return number.String(), nil
+		}
 		return fmt.Sprintf("%v", model), nil
 	}
 	var nextModel interface{}
@@ -623,40 +2517,68 @@ func EvaluateExpression(model interface{}, fieldExpression string) (string, erro
 	return EvaluateExpression(nextModel, nextExpression)
 }
 
+const runAsHeader = "es-security-runas-user"
+
 type KibanaDecorator struct {
 	r             http.RoundTripper
 	kibanaVersion string
 	extraHeaders  map[string]string
+	queryParams   url.Values
+	proxyURL      *url.URL
+	proxyMethod   string
+	runAs         string
+	opaqueId      func() string //generates X-Opaque-Id header values (nil if disabled)
 	configuration *configuration.Configuration
 	cookie        AuthToken
 }
 
 func (mrt KibanaDecorator) RoundTrip(r *http.Request) (*http.Response, error) {
 	mrt.cookie = LoadToken(mrt.configuration)
+	if mrt.runAs != "" {
+		//unlike extra headers, run-as applies to all requests so that no request is executed as the authenticated user
+		r.Header.Set(runAsHeader, mrt.runAs)
+	}
+	if mrt.opaqueId != nil {
+		r.Header.Set(opaqueIdHeader, mrt.opaqueId())
+	}
+
+	//auth cookie and extra headers are needed by all requests (e.g. _cat/indices), not only by searches
+	if mrt.cookie.token != "" {
+		r.AddCookie(&http.Cookie{
+			//HttpOnly: true,
+			Name:  "sid-auth",
+			Value: mrt.cookie.token,
+		})
+	}
+	for k, v := range mrt.extraHeaders {
+		r.Header.Add(k, v)
+	}
+
 	if strings.Contains(r.URL.Path, "_msearch") {
-		r.URL.Path = "/elasticsearch/_msearch"
-		r.Method = "POST"
+		r.URL.Path = mrt.proxyURL.Path
+		r.Method = mrt.proxyMethod
 
 		if mrt.kibanaVersion != "" {
 			r.Header.Add("kbn-version", mrt.kibanaVersion)
 		}
 
-		if mrt.cookie.token != "" {
-			r.AddCookie(&http.Cookie{
-				//HttpOnly: true,
-				Name:  "sid-auth",
-				Value: mrt.cookie.token,
-			})
-		}
-
-		for k, v := range mrt.extraHeaders {
-			r.Header.Add(k, v)
-		}
-
 		q := r.URL.Query()
+		for k, values := range mrt.proxyURL.Query() {
+			for _, v := range values {
+				q.Add(k, v)
+			}
+		}
 		//q.Add("rest_total_hits_as_int", "true")
 		//q.Add("ignore_throttled", "true")
+		for k, values := range mrt.queryParams {
+			for _, v := range values {
+				q.Add(k, v)
+			}
+		}
 		r.URL.RawQuery = q.Encode()
+	} else if mrt.configuration.User != "" && r.Header.Get("Authorization") == "" {
+		//requests that don't go through the Kibana proxy reach elasticsearch directly, which needs basic auth
+		r.SetBasicAuth(mrt.configuration.User, mrt.configuration.Password)
 	}
 	response, e := mrt.r.RoundTrip(r)
 
@@ -664,6 +2586,15 @@ func (mrt KibanaDecorator) RoundTrip(r *http.Request) (*http.Response, error) {
 		e = mrt.cookie.Authenticate()
 		Error.Fatalln("Failed to authenticate. Please run again. If problem still occurs you have authenticate by passing valid credentials with -u flag")
 	}
+	if e == nil && (response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden) &&
+		mrt.cookie.token != "" {
+		//Kibana session may have expired, log in again so the request retried by the client carries a new cookie
+		if err := mrt.cookie.Authenticate(); err != nil {
+			Info.Println("Failed to authenticate again.", err)
+		} else {
+			response.Header.Set(reauthenticatedHeader, "true")
+		}
+	}
 
 	return response, e
 }
@@ -687,10 +2618,16 @@ func userHomeDir() string {
 var confDir = ".elktail"
 var defaultConfFile = "default.json"
 
+// Returns the file the auth cookie is kept in, --auth-cookie-file or auth.cookie in the configuration directory
+func authCookieFile(config *configuration.Configuration) string {
+	if config.AuthCookieFile != "" {
+		return config.AuthCookieFile
+	}
+	return userHomeDir() + string(os.PathSeparator) + confDir + string(os.PathSeparator) + "auth.cookie"
+}
+
 func LoadToken(config *configuration.Configuration) AuthToken {
-	confDirPath := userHomeDir() + string(os.PathSeparator) + confDir
-	confFile := confDirPath + string(os.PathSeparator) + "auth.cookie"
-	tokenBytes, err := ioutil.ReadFile(confFile)
+	tokenBytes, err := ioutil.ReadFile(authCookieFile(config))
 
 	if err != nil {
 		token := AuthToken{config: config}
@@ -740,9 +2677,7 @@ func (ths *AuthToken) Authenticate() error {
 		return fmt.Errorf("bad credentials")
 	}
 
-	confDirPath := userHomeDir() + string(os.PathSeparator) + confDir
-	tokenFile := confDirPath + string(os.PathSeparator) + "auth.cookie"
-	return ioutil.WriteFile(tokenFile, []byte(ths.token), 0700)
+	return configuration.WriteSecretFile(authCookieFile(ths.config), []byte(ths.token))
 }
 
 func ResolveKibanaVersion(url string, extraHeaders map[string]string) (string, error) {
@@ -783,3 +2718,113 @@ func ExtractHeader(s string) []string {
 	}
 	return split
 }
+
+const defaultProxyMethod = configuration.DefaultProxyMethod
+
+// ResolveProxyURL resolves the proxy path template for the given elasticsearch endpoint, replacing {path} in
+// it. Template may also contain query parameters, e.g. /api/console/proxy?path={path}&method=POST
+func ResolveProxyURL(template string, endpoint string) (*url.URL, error) {
+	if template == "" {
+		template = configuration.DefaultProxyPath
+	}
+	proxyURL, err := url.Parse(strings.Replace(template, "{path}", endpoint, -1))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(proxyURL.Path, "/") {
+		return nil, fmt.Errorf("%s is not an absolute path", template)
+	}
+	return proxyURL, nil
+}
+
+// ParseQueryParams parses query parameters given in key=value format
+func ParseQueryParams(params []string) (url.Values, error) {
+	values := url.Values{}
+	for _, param := range params {
+		split := strings.SplitN(param, "=", 2)
+		if len(split) != 2 || strings.TrimSpace(split[0]) == "" {
+			return nil, fmt.Errorf("%s is not in key=value format", param)
+		}
+		values.Add(strings.TrimSpace(split[0]), strings.TrimSpace(split[1]))
+	}
+	return values, nil
+}
+
+// ParseTermHelpers builds wildcard queries from field:value and fuzzy queries from field:value~fuzziness definitions
+// (fuzziness defaults to AUTO when not given)
+func ParseTermHelpers(wildcards []string, fuzzies []string) ([]elastic.Query, error) {
+	var queries []elastic.Query
+	for _, wildcard := range wildcards {
+		field, value, err := parseFieldValue(wildcard)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, elastic.NewWildcardQuery(field, value))
+	}
+	for _, fuzzy := range fuzzies {
+		field, value, err := parseFieldValue(fuzzy)
+		if err != nil {
+			return nil, err
+		}
+		fuzziness := "AUTO"
+		if i := strings.LastIndex(value, "~"); i != -1 {
+			value, fuzziness = value[:i], value[i+1:]
+			if value == "" || fuzziness == "" {
+				return nil, fmt.Errorf("%s is not in field:value~fuzziness format", fuzzy)
+			}
+		}
+		queries = append(queries, elastic.NewFuzzyQuery(field, value).Fuzziness(fuzziness))
+	}
+	return queries, nil
+}
+
+// Builds match_phrase queries for --phrase, so the phrase is matched exactly without query string quoting
+func phraseQueries(field string, phrases []string) []elastic.Query {
+	var queries []elastic.Query
+	for _, phrase := range phrases {
+		queries = append(queries, elastic.NewMatchPhraseQuery(field, phrase))
+	}
+	return queries
+}
+
+// Splits field:value definition
+func parseFieldValue(definition string) (string, string, error) {
+	split := strings.SplitN(definition, ":", 2)
+	if len(split) != 2 || split[0] == "" || split[1] == "" {
+		return "", "", fmt.Errorf("%s is not in field:value format", definition)
+	}
+	return split[0], split[1], nil
+}
+
+// ParseRenames parses field renames given in from=to format
+func ParseRenames(renames []string) ([]fieldRename, error) {
+	result := make([]fieldRename, 0, len(renames))
+	for _, rename := range renames {
+		split := strings.SplitN(rename, "=", 2)
+		if len(split) != 2 || strings.TrimSpace(split[0]) == "" || strings.TrimSpace(split[1]) == "" {
+			return nil, fmt.Errorf("%s is not in from=to format", rename)
+		}
+		result = append(result, fieldRename{from: strings.TrimSpace(split[0]), to: strings.TrimSpace(split[1])})
+	}
+	return result, nil
+}
+
+// ParseRuntimeFields parses runtime field definitions given in name:type:script format into runtime mappings
+func ParseRuntimeFields(definitions []string) (elastic.RuntimeMappings, []string, error) {
+	mappings := elastic.RuntimeMappings{}
+	names := make([]string, 0, len(definitions))
+	for _, definition := range definitions {
+		split := strings.SplitN(definition, ":", 3)
+		if len(split) != 3 || split[0] == "" || split[1] == "" || split[2] == "" {
+			return nil, nil, fmt.Errorf("%s is not in name:type:script format", definition)
+		}
+		mappings[split[0]] = map[string]interface{}{
+			"type": split[1],
+			"script": map[string]interface{}{
+				"source": split[2],
+			},
+		}
+		names = append(names, split[0])
+	}
+	return mappings, names, nil
+}
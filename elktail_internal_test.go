@@ -6,8 +6,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/olivere/elastic/v7"
+	configuration "github.com/piersharding/elktail/configuration"
 	tu "github.com/piersharding/elktail/testutils"
 )
 
@@ -31,6 +50,38 @@ func TestFindIndicesForDateRange(t *testing.T) {
 
 }
 
+func TestAnchorIndexPattern(t *testing.T) {
+	indices := []string{"app-2023.01.01", "happ-logs-2023.01.02", "filebeat-7.17.0-2023.01.01"}
+	tu.AssertEqualsString(t, "happ-logs-2023.01.02", findLastIndex(indices, "app"))
+	tu.AssertEqualsString(t, "app-2023.01.01", findLastIndex(indices, anchorIndexPattern("app")))
+	tu.AssertEqualsString(t, "filebeat-7.17.0-2023.01.01", findLastIndex(indices, anchorIndexPattern("filebeat-*")))
+	tu.AssertEqualsString(t, "app-2023.01.01", findLastIndex(indices, anchorIndexPattern("app|other")))
+
+	x := findIndicesForDateRange(indices, anchorIndexPattern("app"), "2023-01-01", "2023-01-02")
+	tu.AssertEqualsString(t, "app-2023.01.01", strings.Join(x, ","))
+}
+
+func TestFindIndexByOffset(t *testing.T) {
+	indices := []string{"logs-000002", "other-000009", "logs-000003", "logs-000001"}
+	pattern := anchorIndexPattern("logs-")
+	for offset, expected := range []string{"logs-000003", "logs-000002", "logs-000001"} {
+		index, err := findIndexByOffset(indices, pattern, offset)
+		if err != nil {
+			tu.Fail(t, err.Error())
+		}
+		tu.AssertEqualsString(t, expected, index)
+	}
+	if _, err := findIndexByOffset(indices, pattern, 3); err == nil {
+		tu.Fail(t, "Expected error for offset beyond the oldest index")
+	}
+	if _, err := findIndexByOffset(indices, pattern, -1); err == nil {
+		tu.Fail(t, "Expected error for negative offset")
+	}
+	if _, err := findIndexByOffset(indices, anchorIndexPattern("missing"), 0); err == nil {
+		tu.Fail(t, "Expected error when no index matches")
+	}
+}
+
 func TestDrainOldEntries(t *testing.T) {
 	arr := []displayedEntry{
 		{timeStamp: "2016-01-01", id: "1"},
@@ -41,4 +92,1331 @@ func TestDrainOldEntries(t *testing.T) {
 	drainOldEntries(&arr, "2016-01-02")
 	tu.AssertEqualsInt(t, 2, len(arr))
 
+	tests := []struct {
+		name     string
+		entries  []displayedEntry
+		expected string
+	}{
+		{"empty", []displayedEntry{}, ""},
+		{"single old", []displayedEntry{{"2016-01-01", "1"}}, ""},
+		{"single new", []displayedEntry{{"2016-01-02", "1"}}, "1"},
+		{"all old", []displayedEntry{{"2016-01-01", "1"}, {"2016-01-01", "2"}}, ""},
+		{"all new", []displayedEntry{{"2016-01-02", "1"}, {"2016-01-03", "2"}}, "1,2"},
+		{"last new", []displayedEntry{{"2016-01-01", "1"}, {"2016-01-01", "2"}, {"2016-01-03", "3"}}, "3"},
+	}
+	for _, test := range tests {
+		entries := test.entries
+		drainOldEntries(&entries, "2016-01-02")
+		ids := make([]string, len(entries))
+		for i, entry := range entries {
+			ids[i] = entry.id
+		}
+		if strings.Join(ids, ",") != test.expected {
+			tu.Fail(t, "Unexpected entries left for '"+test.name+"': "+strings.Join(ids, ","))
+		}
+	}
+}
+
+func TestIsSelected(t *testing.T) {
+	tail := &Tail{}
+	if !tail.isSelected("anything") {
+		tu.Fail(t, "Expected line to be selected when no grep is configured")
+	}
+	tail.grep = regexp.MustCompile("ERROR|WARN")
+	tail.grepInvert = regexp.MustCompile("healthcheck")
+	if !tail.isSelected("2016-01-01 ERROR failed") {
+		tu.Fail(t, "Expected matching line to be selected")
+	}
+	if tail.isSelected("2016-01-01 INFO started") {
+		tu.Fail(t, "Expected non matching line not to be selected")
+	}
+	if tail.isSelected("2016-01-01 WARN healthcheck slow") {
+		tu.Fail(t, "Expected line matching --grep-v not to be selected")
+	}
+}
+
+func TestFormatSmartResult(t *testing.T) {
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"}}
+	entry := map[string]interface{}{"@timestamp": "2016-01-01T00:00:00.000Z", "message": "started"}
+	tu.AssertEqualsString(t, "2016-01-01T00:00:00.000Z :: started", tail.formatSmartResult(entry, nil))
+	entry = map[string]interface{}{"message": "started"}
+	tu.AssertEqualsString(t, "started", tail.formatSmartResult(entry, nil))
+	source := []byte(`{"log":"started"}`)
+	entry = map[string]interface{}{"log": "started"}
+	tu.AssertEqualsString(t, string(source), tail.formatSmartResult(entry, source))
+}
+
+func TestMissingFieldPlaceholder(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{Format: "%host.name|%user|%message"}}
+	entry := map[string]interface{}{"message": "login", "user": ""}
+	tu.AssertEqualsString(t, "||login", tail.formatResult(entry))
+	tail.missingField = "-"
+	tu.AssertEqualsString(t, "-||login", tail.formatResult(entry))
+}
+
+func TestTimeLayout(t *testing.T) {
+	local := time.Local
+	defer func() { time.Local = local }()
+	time.Local = time.FixedZone("CET", 3600)
+
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp", Format: "%@timestamp %message"}}
+	tail.timeLayout, _ = resolveTimeLayout("kitchen")
+	entry := map[string]interface{}{"@timestamp": "2016-01-01T14:30:00.123Z", "message": "started"}
+	tu.AssertEqualsString(t, "3:30PM started", tail.formatResult(entry))
+	tail.timeLayout, _ = resolveTimeLayout("15:04:05.000")
+	tu.AssertEqualsString(t, "15:30:00.123 :: started", tail.formatSmartResult(entry, nil))
+	tail.timeLayout, _ = resolveTimeLayout("unix")
+	tu.AssertEqualsString(t, "1451658600 started", tail.formatResult(entry))
+	tu.AssertEqualsString(t, "not a timestamp", formatDisplayTimeStamp("not a timestamp", time.Kitchen))
+
+	if _, err := resolveTimeLayout("short"); err == nil {
+		tu.Fail(t, "Expected layout without reference time elements to be rejected")
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{Format: "%host %log.message"},
+		highlight: newHighlight([]string{"log.message"}, false)}
+	body, _ := tail.newSearchRequest().Body()
+	tu.AssertEqualsString(t,
+		`{"highlight":{"fields":{"log.message":{}},"post_tags":["\u003c\u003c"],"pre_tags":["\u003e\u003e"]}}`, body)
+
+	entry := map[string]interface{}{"host": "web-1", "log": map[string]interface{}{"message": "connection timeout to db"}}
+	applyHighlights(entry, elastic.SearchHitHighlight{"log.message": {"connection >>timeout<< to db"}})
+	tu.AssertEqualsString(t, "web-1 connection >>timeout<< to db", tail.formatResult(entry))
+}
+
+func TestSplitEnds(t *testing.T) {
+	hits := func(ids ...string) []*elastic.SearchHit {
+		result := make([]*elastic.SearchHit, len(ids))
+		for i, id := range ids {
+			result[i] = &elastic.SearchHit{Index: "logs", Id: id}
+		}
+		return result
+	}
+	lastHits, omitted := splitEnds(hits("1", "2"), hits("9", "8"), 9)
+	tu.AssertEqualsInt(t, 2, len(lastHits))
+	tu.AssertEqualsInt(t, 5, int(omitted))
+
+	//ends overlap when fewer than twice the count entries match
+	lastHits, omitted = splitEnds(hits("1", "2"), hits("3", "2"), 3)
+	tu.AssertEqualsInt(t, 1, len(lastHits))
+	tu.AssertEqualsString(t, "3", lastHits[0].Id)
+	tu.AssertEqualsInt(t, 0, int(omitted))
+}
+
+func TestLineHistory(t *testing.T) {
+	history := newLineHistory(2)
+	added := ""
+	for _, line := range []string{"a", "b", "a", "c", "b", "a", "a"} {
+		if history.add(line) {
+			added += line
+		}
+	}
+	//b is still remembered after c, a was evicted by c and is printed again
+	tu.AssertEqualsString(t, "abca", added)
+}
+
+func TestUniq(t *testing.T) {
+	var buffer bytes.Buffer
+	tail := &Tail{output: bufio.NewWriter(&buffer), repeatedLines: newRepeatedLines(true)}
+	for _, line := range []string{"a", "a", "a", "b", "c", "c"} {
+		tail.printLine(&elastic.SearchHit{}, line, nil)
+	}
+	tail.finishRepeatedLines()
+	tu.AssertEqualsString(t, "a\n... (repeated 2 times)\nb\nc\n... (repeated 1 time)\n", buffer.String())
+
+	run := newRepeatedLines(false)
+	run.add("a")
+	repeated, summary := run.add("a")
+	if !repeated || summary != "" {
+		tu.Fail(t, "Expected repeated line without summary")
+	}
+}
+
+func TestWriteBulkEntry(t *testing.T) {
+	hit := &elastic.SearchHit{
+		Index:  "logstash-2016.06.15",
+		Id:     "abc",
+		Source: []byte("{\n  \"message\": \"started\"\n}"),
+	}
+	var buffer bytes.Buffer
+	writeBulkEntry(&buffer, hit)
+	tu.AssertEqualsString(t, "{\"index\":{\"_index\":\"logstash-2016.06.15\",\"_id\":\"abc\"}}\n{\"message\":\"started\"}\n", buffer.String())
+
+	buffer.Reset()
+	writeSourceEntry(&buffer, hit)
+	tu.AssertEqualsString(t, "{\"message\":\"started\"}\n", buffer.String())
+}
+
+func TestMergeHits(t *testing.T) {
+	hit := func(id string, sort float64) *elastic.SearchHit {
+		return &elastic.SearchHit{Id: id, Sort: []interface{}{sort}}
+	}
+	lists := [][]*elastic.SearchHit{
+		{hit("a1", 5), hit("a2", 3), hit("a3", 1)},
+		{},
+		{hit("b1", 4), hit("b2", 2)},
+	}
+	merged := mergeHits(lists, false, 4)
+	tu.AssertEqualsInt(t, 4, len(merged))
+	ids := ""
+	for _, h := range merged {
+		ids += h.Id + " "
+	}
+	tu.AssertEqualsString(t, "a1 b1 a2 b2 ", ids)
+
+	lists = [][]*elastic.SearchHit{
+		{hit("a1", 1), hit("a2", 3)},
+		{hit("b1", 2)},
+	}
+	merged = mergeHits(lists, true, 10)
+	tu.AssertEqualsInt(t, 3, len(merged))
+	tu.AssertEqualsString(t, "b1", merged[1].Id)
+}
+
+func TestIsDateMathIndex(t *testing.T) {
+	if !isDateMathIndex("<logstash-{now/d}>") {
+		tu.Fail(t, "Expected <logstash-{now/d}> to be recognized as date math index")
+	}
+	if isDateMathIndex("logstash-*") {
+		tu.Fail(t, "Expected logstash-* not to be recognized as date math index")
+	}
+}
+
+func TestEscapeQueryString(t *testing.T) {
+	tu.AssertEqualsString(t, `\/api\/v1\/users AND status\:500`, escapeQueryString("/api/v1/users AND status:500"))
+	tu.AssertEqualsString(t, `\[a TO b\]`, escapeQueryString("[a TO b]"))
+	tu.AssertEqualsString(t, `a  b`, escapeQueryString("a < b"))
+	tu.AssertEqualsString(t, `c\:\\temp`, escapeQueryString(`c:\temp`))
+}
+
+func TestHistogramBar(t *testing.T) {
+	tu.AssertEqualsString(t, "##########", histogramBar(100, 100, 10))
+	tu.AssertEqualsString(t, "#####", histogramBar(50, 100, 10))
+	tu.AssertEqualsString(t, "#", histogramBar(1, 100, 10))
+	tu.AssertEqualsString(t, "", histogramBar(0, 100, 10))
+	tu.AssertEqualsString(t, "", histogramBar(0, 0, 10))
+}
+
+func benchmarkProcessHit(b *testing.B, lineBuffered bool) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	out, err := ioutil.TempFile("", "elktail-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	tail := &Tail{
+		queryDefinition: &configuration.QueryDefinition{Format: "%@timestamp :: %message", TimestampField: "@timestamp"},
+		output:          bufio.NewWriter(out),
+		lineBuffered:    lineBuffered,
+	}
+	hit := &elastic.SearchHit{Source: []byte(`{"@timestamp":"2016-01-01T00:00:00.000Z","message":"started"}`)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tail.processHit(hit)
+	}
+	tail.output.Flush()
+}
+
+func BenchmarkProcessHitBuffered(b *testing.B) {
+	benchmarkProcessHit(b, false)
+}
+
+func BenchmarkProcessHitLineBuffered(b *testing.B) {
+	benchmarkProcessHit(b, true)
+}
+
+func TestWriteExplanation(t *testing.T) {
+	explanation := &elastic.SearchExplanation{
+		Value:       1.5,
+		Description: "sum of:",
+		Details: []elastic.SearchExplanation{
+			{Value: 1.5, Description: "weight(message:error)"},
+		},
+	}
+	var buffer bytes.Buffer
+	writeExplanation(&buffer, explanation, 1)
+	tu.AssertEqualsString(t, "  1.5 = sum of:\n    1.5 = weight(message:error)\n", buffer.String())
+}
+
+func TestBuildSearchQueryTraceId(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{
+		TimestampField: "@timestamp",
+		TraceId:        "4bf92f3577b34da6",
+		TraceField:     "trace.id",
+	}}
+	tu.AssertEqualsString(t,
+		`{"bool":{"filter":[{"match_all":{}},{"term":{"trace.id":"4bf92f3577b34da6"}}]}}`,
+		querySource(t, tail.buildSearchQuery()))
+}
+
+func TestBuildSearchQueryKubernetes(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{
+		TimestampField: "@timestamp",
+		Terms:          []string{"error"},
+		K8sNamespace:   "shop",
+		K8sContainer:   "api",
+	}}
+	tu.AssertEqualsString(t,
+		`{"bool":{"filter":[{"query_string":{"query":"error"}},{"term":{"kubernetes.namespace":"shop"}},`+
+			`{"term":{"kubernetes.container.name":"api"}}]}}`,
+		querySource(t, tail.buildSearchQuery()))
+}
+
+func TestExcludeTime(t *testing.T) {
+	window, err := parseTimeOfDayWindow("22:30-02:00")
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsInt(t, 1350, window.start)
+	tu.AssertEqualsInt(t, 120, window.end)
+	for _, invalid := range []string{"02:00", "2am-3am", "02:00-02:00", "25:00-03:00"} {
+		if _, err := parseTimeOfDayWindow(invalid); err == nil {
+			tu.Fail(t, "Expected error for window "+invalid)
+		}
+	}
+
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp", ExcludeTime: "02:00-03:00"}}
+	tail.excludedWindow, _ = parseTimeOfDayWindow(tail.queryDefinition.ExcludeTime)
+	source := querySource(t, tail.buildSearchQuery())
+	if !strings.Contains(source, `"params":{"end":180,"field":"@timestamp","start":120}`) {
+		tu.Fail(t, "Expected script filter with the window in query: "+source)
+	}
+}
+
+func TestBuildSearchQueryAny(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{
+		TimestampField: "@timestamp",
+		AnyTerms:       []string{"timeout", "refused"},
+	}}
+	tu.AssertEqualsString(t,
+		`{"bool":{"minimum_should_match":"1","should":[{"query_string":{"query":"timeout"}},`+
+			`{"query_string":{"query":"refused"}}]}}`,
+		querySource(t, tail.buildSearchQuery()))
+
+	tail.queryDefinition.Terms = []string{"service:db"}
+	tail.queryDefinition.TraceId = "4bf92f3577b34da6"
+	tail.queryDefinition.TraceField = "trace.id"
+	tu.AssertEqualsString(t,
+		`{"bool":{"filter":[{"query_string":{"query":"service:db"}},{"bool":{"minimum_should_match":"1","should":[`+
+			`{"query_string":{"query":"timeout"}},{"query_string":{"query":"refused"}}]}},{"term":{"trace.id":"4bf92f3577b34da6"}}]}}`,
+		querySource(t, tail.buildSearchQuery()))
+}
+
+func querySource(t *testing.T, query elastic.Query) string {
+	source, err := query.Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	js, err := json.Marshal(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(js)
+}
+
+func TestRenameFields(t *testing.T) {
+	renames, err := ParseRenames([]string{"log.level=severity", "missing=other"})
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	entry := map[string]interface{}{
+		"log":     map[string]interface{}{"level": "WARN", "logger": "main"},
+		"message": "disk almost full",
+	}
+	renameFields(entry, renames)
+	js, _ := json.Marshal(entry)
+	tu.AssertEqualsString(t, `{"log":{"logger":"main"},"message":"disk almost full","severity":"WARN"}`, string(js))
+
+	_, err = ParseRenames([]string{"log.level"})
+	if err == nil {
+		tu.Fail(t, "Expected error for rename without target")
+	}
+}
+
+func TestNDJSONMetadataFields(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	var out bytes.Buffer
+	tail := &Tail{
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"},
+		output:          bufio.NewWriter(&out),
+		ndjson:          true,
+		showIndex:       true,
+		color:           true,
+		label:           formatLabel("errors", "red", true),
+		queryName:       "errors",
+		fields:          []string{"message"},
+	}
+	hit := &elastic.SearchHit{Id: "1", Index: "logs-1",
+		Source: []byte(`{"@timestamp":"2022-01-01T00:00:01.000Z","level":"ERROR","message":"boom"}`)}
+	tail.processHit(hit)
+	tail.fields = nil
+	tail.processHit(hit)
+	tail.output.Flush()
+	tu.AssertEqualsString(t, `{"_query":"errors","_index":"logs-1","message":"boom"}`+"\n"+
+		`{"@timestamp":"2022-01-01T00:00:01.000Z","_index":"logs-1","_query":"errors","level":"ERROR","message":"boom"}`+"\n",
+		out.String())
+}
+
+func TestRenameTimestampField(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	renames, _ := ParseRenames([]string{"@timestamp=time"})
+	var out bytes.Buffer
+	tail := &Tail{
+		queryDefinition: &configuration.QueryDefinition{Format: "%time %message", TimestampField: "@timestamp"},
+		output:          bufio.NewWriter(&out),
+		renames:         renames,
+	}
+	tail.processResultHit(&elastic.SearchHit{Id: "1",
+		Source: []byte(`{"@timestamp":"2022-01-01T00:00:01.000Z","message":"started"}`)}, false)
+	tail.processResultHit(&elastic.SearchHit{Id: "2", Source: []byte(`{"message":"no timestamp"}`)}, false)
+	tail.output.Flush()
+	tu.AssertEqualsString(t, "2022-01-01T00:00:01.000Z started\n no timestamp\n", out.String())
+	tu.AssertEqualsString(t, "2022-01-01T00:00:01.000Z", tail.lastTimeStamp)
+	tu.AssertEqualsInt(t, 1, len(tail.lastIDs))
+}
+
+func TestColorEnabled(t *testing.T) {
+	file, err := ioutil.TempFile("", "elktail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	for mode, expected := range map[string]bool{"always": true, "never": false, "auto": false} {
+		enabled, err := colorEnabled(mode, file)
+		if err != nil {
+			tu.Fail(t, err.Error())
+		}
+		if enabled != expected {
+			tu.Fail(t, "Unexpected color setting for mode "+mode)
+		}
+	}
+	if _, err := colorEnabled("sometimes", file); err == nil {
+		tu.Fail(t, "Expected error for invalid color mode")
+	}
+}
+
+func TestColorizeLine(t *testing.T) {
+	tu.AssertEqualsString(t, "\033[31mboom\033[0m", colorizeLine("boom", map[string]interface{}{"level": "error"}))
+	tu.AssertEqualsString(t, "\033[33mhmm\033[0m",
+		colorizeLine("hmm", map[string]interface{}{"log": map[string]interface{}{"level": "WARN"}}))
+	tu.AssertEqualsString(t, "fine", colorizeLine("fine", map[string]interface{}{"level": "INFO"}))
+	tu.AssertEqualsString(t, "plain", colorizeLine("plain", map[string]interface{}{"message": "plain"}))
+}
+
+func TestAutoSourceFields(t *testing.T) {
+	tail := &Tail{
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp", Format: "%host %{severity} - %message %day"},
+		renames:         []fieldRename{{from: "log.level", to: "severity"}},
+		runtimeFields:   []string{"day"},
+	}
+	tu.AssertEqualsString(t, "@timestamp,host,log.level,message", strings.Join(tail.autoSourceFields(), ","))
+
+	tail.queryDefinition.Format = "%host %{$.labels[*]}"
+	if tail.autoSourceFields() != nil {
+		tu.Fail(t, "Expected whole source for format with JSONPath")
+	}
+	tail.queryDefinition.Format = "no fields"
+	if tail.autoSourceFields() != nil {
+		tu.Fail(t, "Expected whole source for format without fields")
+	}
+}
+
+func TestAutoSourceFieldsDedupIdField(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp", Format: "%message"},
+		dedupIdField:    "event.id",
+		decoders:        []fieldDecoder{{field: "payload", encoding: "base64"}},
+		unescape:        []string{"stack"},
+		highlightFields: []string{"error"},
+		output:          bufio.NewWriter(ioutil.Discard),
+	}
+	tail.sourceFields = tail.autoSourceFields()
+	tu.AssertEqualsString(t, "@timestamp,message,event.id,payload,stack,error", strings.Join(tail.sourceFields, ","))
+
+	//entry fetched with only the auto fields still has the id it's deduplicated by
+	tail.processResultHit(&elastic.SearchHit{Id: "1",
+		Source: []byte(`{"@timestamp":"2022-01-01T00:00:01.000Z","message":"started","event":{"id":"e-1"}}`)}, false)
+	if len(tail.lastIDs) != 1 || tail.lastIDs[0].id != "e-1" {
+		tu.Fail(t, "Expected entry to be tracked by its --dedup-id-field")
+	}
+}
+
+func TestWithFieldsAPI(t *testing.T) {
+	searchRequest := elastic.NewSearchRequest().Query(elastic.NewMatchAllQuery()).Size(10).
+		FetchSourceIncludeExclude([]string{"message"}, nil)
+	searchRequest, err := withFieldsAPI(searchRequest, []string{"@timestamp", "message"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := searchRequest.Body()
+	tu.AssertEqualsString(t, `{"_source":false,"fields":["@timestamp","message"],"query":{"match_all":{}},"size":10}`, body)
+
+	searchRequest, _ = withFieldsAPI(elastic.NewSearchRequest(), nil)
+	body, _ = searchRequest.Body()
+	tu.AssertEqualsString(t, `{"_source":false,"fields":["*"]}`, body)
+}
+
+func TestFieldsEntry(t *testing.T) {
+	entry := fieldsEntry(map[string]interface{}{
+		"@timestamp":  []interface{}{"2022-03-01T10:00:00.000Z"},
+		"client.ip":   []interface{}{"10.0.0.1"},
+		"tags":        []interface{}{"a", "b"},
+		"empty.field": []interface{}{},
+	})
+	tu.AssertEqualsInt(t, 3, len(entry))
+	value, _ := EvaluateExpression(entry, "client.ip")
+	tu.AssertEqualsString(t, "10.0.0.1", value)
+	value, _ = EvaluateExpression(entry, "tags")
+	tu.AssertEqualsString(t, "[a b]", value)
+}
+
+func TestBuildSearchQuerySample(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{
+		TimestampField: "@timestamp",
+		Sample:         10,
+	}}
+	tu.AssertEqualsString(t,
+		`{"function_score":{"boost_mode":"replace","functions":[{"random_score":{}}],"min_score":0.9,"query":{"match_all":{}}}}`,
+		querySource(t, tail.buildSearchQuery()))
+}
+
+func TestFormatNDJSON(t *testing.T) {
+	entry := map[string]interface{}{
+		"message":  "disk almost full",
+		"log":      map[string]interface{}{"level": "WARN", "origin": map[string]interface{}{"file": "disk.go"}},
+		"tags":     []interface{}{"a", "b"},
+		"severity": "WARN",
+	}
+	line, err := formatNDJSON(entry, nil, true)
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t,
+		`{"log.level":"WARN","log.origin.file":"disk.go","message":"disk almost full","severity":"WARN","tags":["a","b"]}`, line)
+
+	line, err = formatNDJSON(entry, parseFieldList([]string{"log.origin, message"}), false)
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, `{"log.origin.file":"disk.go","message":"disk almost full"}`, line)
+
+	line, err = formatNDJSON(entry, parseFieldList([]string{"severity,message,log,missing"}), true)
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t,
+		`{"severity":"WARN","message":"disk almost full","log.level":"WARN","log.origin.file":"disk.go"}`, line)
+
+	line, err = formatNDJSON(entry, parseFieldList([]string{"severity,message"}), false)
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, `{"message":"disk almost full","severity":"WARN"}`, line)
+}
+
+func TestBuildSearchQueryMinScore(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{
+		Terms:          []string{"conection~"},
+		TimestampField: "@timestamp",
+		TraceId:        "4bf92f3577b34da6",
+		TraceField:     "trace.id",
+		MinScore:       0.5,
+	}}
+	tu.AssertEqualsString(t,
+		`{"bool":{"filter":{"term":{"trace.id":"4bf92f3577b34da6"}},"must":{"query_string":{"query":"conection~"}}}}`,
+		querySource(t, tail.buildSearchQuery()))
+}
+
+func TestBuildSearchQueryTermHelpers(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	termQueries, err := ParseTermHelpers([]string{"host:web-*"}, []string{"user:jonh~1"})
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tail := &Tail{
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"},
+		termQueries:     termQueries,
+	}
+	tu.AssertEqualsString(t,
+		`{"bool":{"must":[{"wildcard":{"host":{"value":"web-*"}}},{"fuzzy":{"user":{"fuzziness":"1","value":"jonh"}}}]}}`,
+		querySource(t, tail.buildSearchQuery()))
+
+	tail.queryDefinition.Terms = []string{"error"}
+	tail.termQueries, _ = ParseTermHelpers(nil, []string{"user:jonh"})
+	tu.AssertEqualsString(t,
+		`{"bool":{"must":[{"query_string":{"query":"error"}},{"fuzzy":{"user":{"fuzziness":"AUTO","value":"jonh"}}}]}}`,
+		querySource(t, tail.buildSearchQuery()))
+
+	tail.termQueries, _ = ParseTermHelpers([]string{"host:web-*"}, nil)
+	tail.queryDefinition.AfterDateTime = "2022-01-01T00:00:00.000Z"
+	tu.AssertEqualsString(t,
+		`{"bool":{"filter":[{"query_string":{"query":"error"}},{"wildcard":{"host":{"value":"web-*"}}},`+
+			`{"range":{"@timestamp":{"from":"2022-01-01T00:00:00.000Z","include_lower":true,"include_upper":true,"to":null}}}]}}`,
+		querySource(t, tail.buildSearchQuery()))
+
+	for _, invalid := range [][]string{{"host"}, {":web-*"}} {
+		if _, err := ParseTermHelpers(invalid, nil); err == nil {
+			tu.Fail(t, "Expected error for wildcard "+invalid[0])
+		}
+	}
+	if _, err := ParseTermHelpers(nil, []string{"user:jonh~"}); err == nil {
+		tu.Fail(t, "Expected error for fuzzy without fuzziness after ~")
+	}
+
+	tail = &Tail{queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"},
+		termQueries: phraseQueries("message", []string{`connection "reset" by peer`})}
+	tu.AssertEqualsString(t, `{"match_phrase":{"message":{"query":"connection \"reset\" by peer"}}}`,
+		querySource(t, tail.buildSearchQuery()))
+}
+
+func TestCapEntries(t *testing.T) {
+	entries := []displayedEntry{{"2022-01-01T00:00:00.001Z", "a"}, {"2022-01-01T00:00:00.002Z", "b"}, {"2022-01-01T00:00:00.003Z", "c"}}
+	if capEntries(&entries, 0) || capEntries(&entries, 3) {
+		tu.Fail(t, "Expected no entries to be evicted")
+	}
+	if !capEntries(&entries, 2) {
+		tu.Fail(t, "Expected entries to be evicted")
+	}
+	tu.AssertEqualsInt(t, 2, len(entries))
+	tu.AssertEqualsString(t, "b", entries[0].id)
+	tu.AssertEqualsString(t, "c", entries[1].id)
+}
+
+func TestFormatProgress(t *testing.T) {
+	tu.AssertEqualsString(t, "Exported 1500/6000 entries (25%), 300 entries/s", formatProgress(1500, 6000, 5*time.Second))
+	tu.AssertEqualsString(t, "Exported 0/0 entries (100%), 0 entries/s", formatProgress(0, 0, 0))
+}
+
+func TestBuildTimestampFilteredQueryDedupIdField(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"},
+		lastTimeStamp:   "2022-01-01T00:00:01.000Z",
+		dedupIdField:    "event.id",
+	}
+	entry := map[string]interface{}{"event": map[string]interface{}{"id": "e-1"}}
+	tail.trackEntry(&elastic.SearchHit{Id: "auto-1"}, entry, "2022-01-01T00:00:01.000Z")
+	tail.trackEntry(&elastic.SearchHit{Id: "auto-2"}, map[string]interface{}{}, "2022-01-01T00:00:01.000Z")
+	tu.AssertEqualsInt(t, 1, len(tail.lastIDs))
+	tu.AssertEqualsString(t,
+		`{"bool":{"filter":[{"match_all":{}},{"bool":{"filter":{"range":{"@timestamp":{"from":"2022-01-01T00:00:00.5Z",`+
+			`"include_lower":true,"include_upper":true,"to":null}}},"must_not":{"terms":{"event.id":["e-1"]}}}}]}}`,
+		querySource(t, tail.buildTimestampFilteredQuery()))
+}
+
+func TestTrackUpdates(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	tail := &Tail{
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"},
+		lastTimeStamp:   "2022-01-01T00:00:01.000Z",
+		trackUpdates:    true,
+	}
+	seqNo, primaryTerm, updatedSeqNo := int64(3), int64(1), int64(4)
+	displayed := &elastic.SearchHit{Id: "a", SeqNo: &seqNo, PrimaryTerm: &primaryTerm}
+	tail.trackEntry(displayed, map[string]interface{}{}, "2022-01-01T00:00:01.000Z")
+	if !tail.isDisplayed(displayed) {
+		tu.Fail(t, "Expected unchanged entry to be skipped")
+	}
+	if tail.isDisplayed(&elastic.SearchHit{Id: "a", SeqNo: &updatedSeqNo, PrimaryTerm: &primaryTerm}) {
+		tu.Fail(t, "Expected updated entry to be displayed again")
+	}
+	if tail.isDisplayed(&elastic.SearchHit{Id: "b", SeqNo: &seqNo, PrimaryTerm: &primaryTerm}) {
+		tu.Fail(t, "Expected new entry to be displayed")
+	}
+	//displayed entries are not excluded from the query, so their updates are fetched
+	tu.AssertEqualsString(t,
+		`{"bool":{"filter":[{"match_all":{}},{"bool":{"filter":{"range":{"@timestamp":{"from":"2022-01-01T00:00:00.5Z",`+
+			`"include_lower":true,"include_upper":true,"to":null}}}}}]}}`,
+		querySource(t, tail.buildTimestampFilteredQuery()))
+
+	//full batch including the displayed entry is not truncated, only new or updated entries count
+	var warnings bytes.Buffer
+	InitLogging(ioutil.Discard, ioutil.Discard, &warnings, false)
+	tail.batchSize = 2
+	tail.checkTruncation(newSearchResult([]*elastic.SearchHit{{Id: "b"}, displayed}, 2))
+	tu.AssertEqualsString(t, "", warnings.String())
+	tail.checkTruncation(newSearchResult([]*elastic.SearchHit{{Id: "b"}, {Id: "c"}}, 3))
+	if !strings.Contains(warnings.String(), "--batch-size") {
+		tu.Fail(t, "Expected warning for a batch of new entries")
+	}
+
+	//versions of entries which left the tailing window are forgotten
+	tail.lastIDs = nil
+	tail.drainDisplayedVersions()
+	tu.AssertEqualsInt(t, 0, len(tail.displayedVersion))
+}
+
+func TestUnescapeFields(t *testing.T) {
+	entry := map[string]interface{}{
+		"message": "boom",
+		"error":   map[string]interface{}{"stack_trace": "java.lang.NullPointerException\\n\\tat Main.run(Main.java:10)\r\n"},
+	}
+	unescapeFields(entry, []string{"error.stack_trace", "message", "missing"})
+	tu.AssertEqualsString(t, "boom", entry["message"].(string))
+	tu.AssertEqualsString(t, "java.lang.NullPointerException\n    \tat Main.run(Main.java:10)",
+		entry["error"].(map[string]interface{})["stack_trace"].(string))
+}
+
+func TestVersionWarnings(t *testing.T) {
+	tests := []struct {
+		version  serverVersion
+		warnings int
+	}{
+		{serverVersion{Number: "7.17.3"}, 0},
+		{serverVersion{Number: "7.10.2"}, 1},
+		{serverVersion{Number: "6.8.0"}, 1},
+		{serverVersion{Number: "8.4.1"}, 1},
+		{serverVersion{Distribution: "opensearch", Number: "2.3.0"}, 1},
+		{serverVersion{Distribution: "opensearch", Number: "3.0.0"}, 1},
+	}
+	for _, test := range tests {
+		tu.AssertEqualsInt(t, test.warnings, len(versionWarnings(&test.version)))
+	}
+	tu.AssertEqualsString(t, "Elasticsearch 6.8.0 is not supported by elktail (supported versions are 7.x). "+
+		"Searches are likely to fail.", versionWarnings(&serverVersion{Number: "6.8.0"})[0])
+}
+
+func TestTemplateFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "elktail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("{{.message}}\n{{field . \"log.level\"}}\n")
+	file.Close()
+
+	entryTemplate, err := loadTemplateFile(file.Name(), false)
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	line, err := entryTemplate.render(map[string]interface{}{
+		"message": "disk almost full",
+		"log":     map[string]interface{}{"level": "WARN"},
+	})
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, "disk almost full\nWARN", line)
+
+	ioutil.WriteFile(file.Name(), []byte("{{.message}}\n{{bogus .}}"), 0600)
+	_, err = loadTemplateFile(file.Name(), false)
+	if err == nil || !strings.Contains(err.Error(), filepath.Base(file.Name())+":2:") {
+		tu.Fail(t, "Expected parse error pointing at the template line")
+	}
+}
+
+func TestPickFields(t *testing.T) {
+	sample := flattenEntry(map[string]interface{}{
+		"@timestamp": "2022-01-01T00:00:00.000Z",
+		"log":        map[string]interface{}{"level": "WARN"},
+		"message":    "disk almost full",
+	})
+	var out bytes.Buffer
+	//fields are listed sorted, picked in the order of the keys: message, @timestamp and log.level picked twice
+	picked, err := pickFields(sample, strings.NewReader("\033[B\033[B\033[B kk j  \r"), &out)
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, "message,@timestamp", strings.Join(picked, ","))
+	if !strings.Contains(out.String(), "[ 2.] @timestamp") || !strings.Contains(out.String(), "2/3 fields picked") {
+		tu.Fail(t, "Expected picked fields to be marked in the order they were picked")
+	}
+
+	//Enter picks the field under the cursor if none was picked
+	picked, _ = pickFields(sample, strings.NewReader("j\r"), &out)
+	tu.AssertEqualsString(t, "log.level", strings.Join(picked, ","))
+	if _, err := pickFields(sample, strings.NewReader(" \003"), &out); err == nil {
+		tu.Fail(t, "Expected Ctrl-C to cancel picking")
+	}
+
+	//only the rows above the status line are drawn, scrolled to the cursor
+	var screen bytes.Buffer
+	picker := newFieldPicker(sample, &screen, func() int { return 3 })
+	picker.handleKey('j')
+	screen.Reset()
+	picker.handleKey('j')
+	if strings.Contains(screen.String(), "@timestamp") || !strings.Contains(screen.String(), "message") {
+		tu.Fail(t, "Expected picker to scroll to the cursor: "+screen.String())
+	}
+}
+
+func TestPickFieldsFieldsAPI(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"responses":[{"status":200,"hits":{"total":{"value":1,"relation":"eq"},"hits":[{"_id":"1",` +
+			`"fields":{"@timestamp":["2022-01-01T00:00:00.000Z"],"log.level":["WARN"],"message":["disk almost full"]}}]}}]}`))
+	}))
+	defer server.Close()
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail := &Tail{client: client, indices: []string{"logs-*"}, useFieldsAPI: true, ndjson: true,
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"}}
+	var out bytes.Buffer
+	if err := tail.PickFields(strings.NewReader("j j \r"), &out); err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, "log.level,message", strings.Join(tail.fields, ","))
+}
+
+func TestRawFastPath(t *testing.T) {
+	var out bytes.Buffer
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"}, raw: true,
+		output: bufio.NewWriter(&out)}
+	hits := []*elastic.SearchHit{
+		{Id: "2", Source: []byte(`{"@timestamp":"2022-01-01T00:00:02.000Z"}`)},
+		{Id: "1", Source: []byte(`{"@timestamp":"2022-01-01T00:00:01.000Z"}`)},
+	}
+	tail.processResults(newSearchResult(hits, 2))
+	tu.AssertEqualsString(t, string(hits[1].Source)+"\n"+string(hits[0].Source)+"\n", out.String())
+	tu.AssertEqualsString(t, "", tail.lastTimeStamp)
+
+	tail.follow = true
+	tail.processResults(newSearchResult(hits, 2))
+	tu.AssertEqualsString(t, "2022-01-01T00:00:02.000Z", tail.lastTimeStamp)
+}
+
+func TestOutputSinks(t *testing.T) {
+	var out, file bytes.Buffer
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp", Format: "%message"},
+		output: bufio.NewWriter(&out), sinks: []outputSink{newJSONLinesSink(&file)}, grep: regexp.MustCompile("started")}
+	hits := []*elastic.SearchHit{
+		{Id: "2", Source: []byte("{\n  \"@timestamp\": \"2022-01-01T00:00:02.000Z\",\n  \"message\": \"stopped\"\n}")},
+		{Id: "1", Source: []byte("{\n  \"@timestamp\": \"2022-01-01T00:00:01.000Z\",\n  \"message\": \"started\"\n}")},
+	}
+	tail.processResults(newSearchResult(hits, 2))
+	tu.AssertEqualsString(t, "started\n", out.String())
+	tu.AssertEqualsString(t, `{"@timestamp":"2022-01-01T00:00:01.000Z","message":"started"}`+"\n", file.String())
+
+	//file created by the sink is closed with it
+	dir, err := ioutil.TempDir("", "elktail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "entries.json")
+	sink, err := newFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail.sinks = []outputSink{sink}
+	tail.processResults(newSearchResult(hits, 2))
+	tail.closeSinks()
+	written, _ := ioutil.ReadFile(path)
+	tu.AssertEqualsString(t, file.String(), string(written))
+	if sink.close() == nil {
+		tu.Fail(t, "Expected closed file not to be closed again")
+	}
+}
+
+// Compares raw list-only dump of a large result page with and without parsing entries
+func BenchmarkProcessResultsRaw(b *testing.B) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	hits := make([]*elastic.SearchHit, 10000)
+	for i := range hits {
+		hits[i] = &elastic.SearchHit{Id: strconv.Itoa(i), Source: []byte(fmt.Sprintf(
+			`{"@timestamp":"2022-01-01T00:00:%02d.000Z","host":"web-%d","message":"GET /api/orders/%d 200 12ms",`+
+				`"kubernetes":{"namespace":"shop","pod":{"name":"api-7d9f"}}}`, i%60, i%8, i))}
+	}
+	result := newSearchResult(hits, int64(len(hits)))
+	for _, follow := range []bool{false, true} {
+		b.Run(fmt.Sprintf("follow=%t", follow), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tail := &Tail{queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"}, raw: true,
+					follow: follow, maxDedupIDs: len(hits), output: bufio.NewWriter(ioutil.Discard)}
+				tail.processResults(result)
+			}
+		})
+	}
+}
+
+func TestRollingView(t *testing.T) {
+	var screen bytes.Buffer
+	view := newRollingView(3, &screen, func() int { return 3 })
+	io.WriteString(view, "GET /a 200\nPOST /b 500\n")
+	io.WriteString(view, "GET /c 5")
+	io.WriteString(view, "00\nGET /d 200\n")
+
+	//first line was dropped from the ring buffer, only the last 2 fit on the screen above the status line
+	lines, matching := view.visibleLines(2)
+	tu.AssertEqualsString(t, "GET /c 500|GET /d 200", strings.Join(lines, "|"))
+	tu.AssertEqualsInt(t, 3, matching)
+
+	for _, key := range []byte("x500") {
+		view.handleKey(key)
+	}
+	view.handleKey(keyBackspace)
+	view.handleKey(keyCtrlU)
+	for _, key := range []byte(" 500") {
+		view.handleKey(key)
+	}
+	lines, _ = view.visibleLines(2)
+	tu.AssertEqualsString(t, "POST /b 500|GET /c 500", strings.Join(lines, "|"))
+	if !strings.Contains(screen.String(), "filter:  500") {
+		tu.Fail(t, "Expected filter in the status line")
+	}
+	if view.handleKey(keyCtrlC) {
+		tu.Fail(t, "Expected Ctrl-C to close the view")
+	}
+}
+
+func TestSeedFromDocument(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"responses":[{"status":200,"hits":{"total":{"value":1,"relation":"eq"},"hits":[` +
+			`{"_index":"logs-2022.01.01","_id":"abc","_source":{"@timestamp":"2022-01-01T00:00:05.000Z"}}]}}]}`))
+	}))
+	defer server.Close()
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail := &Tail{client: client, indices: []string{"logs-2022.01.01"},
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"}}
+	if err := tail.seedFromDocument("abc"); err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, "2022-01-01T00:00:05.000Z", tail.lastTimeStamp)
+	tu.AssertEqualsInt(t, 1, len(tail.lastIDs))
+	tu.AssertEqualsString(t, "abc", tail.lastIDs[0].id)
+}
+
+func TestDrainOldEntriesNanos(t *testing.T) {
+	//as strings, "...05.1Z" sorts after "...05.123456789Z" and "...05Z" after "...05.1Z"
+	entries := []displayedEntry{
+		{timeStamp: "2023-01-01T10:00:05Z", id: "1"},
+		{timeStamp: "2023-01-01T10:00:05.1Z", id: "2"},
+		{timeStamp: "2023-01-01T10:00:05.100000001Z", id: "3"},
+		{timeStamp: "2023-01-01T10:00:05.123456789Z", id: "4"},
+	}
+	drainOldEntries(&entries, "2023-01-01T10:00:05.100000001Z")
+	tu.AssertEqualsInt(t, 2, len(entries))
+	tu.AssertEqualsString(t, "3", entries[0].id)
+}
+
+func TestTailingWindowStart(t *testing.T) {
+	tail := &Tail{lastTimeStamp: "2023-01-01T10:00:05.123456789Z"}
+	tu.AssertEqualsString(t, "2023-01-01T10:00:04.623Z", tail.tailingWindowStart())
+	tail.nanos = true
+	tu.AssertEqualsString(t, "2023-01-01T10:00:04.623456789Z", tail.tailingWindowStart())
+}
+
+func TestIsDateNanosMapping(t *testing.T) {
+	mapping := map[string]interface{}{
+		"logs-1": map[string]interface{}{"mappings": map[string]interface{}{
+			"event.created": map[string]interface{}{"mapping": map[string]interface{}{
+				"created": map[string]interface{}{"type": "date_nanos"},
+			}},
+		}},
+	}
+	if !isDateNanosMapping(mapping, "event.created") {
+		tu.Fail(t, "Expected event.created to be detected as date_nanos")
+	}
+	if isDateNanosMapping(mapping, "@timestamp") {
+		tu.Fail(t, "Expected missing field not to be detected as date_nanos")
+	}
+}
+
+func TestReconnectHookCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook command uses sh")
+	}
+	cmd := reconnectHookCommand(`echo "$ELKTAIL_RECONNECT_SERVER $ELKTAIL_RECONNECT_ATTEMPTS $ELKTAIL_RECONNECT_ERROR"`,
+		"bastion:22", 3, fmt.Errorf("connection refused"))
+	output, err := cmd.Output()
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, "bastion:22 3 connection refused\n", string(output))
+}
+
+func TestListIndices(t *testing.T) {
+	rows := elastic.CatIndicesResponse{
+		{Index: "logs-2023.01.03", DocsCount: 30, StoreSize: "3mb"},
+		{Index: "logs-2023.01.01", DocsCount: 10, StoreSize: "1mb"},
+		{Index: "logs-archive", DocsCount: 5, StoreSize: "5kb"},
+		{Index: "logs-2023.01.02", DocsCount: 20, StoreSize: "2mb"},
+	}
+	var out bytes.Buffer
+	writeIndices(&out, filterIndicesForDateRange(rows, "2023-01-02T10:00", ""), false, false)
+	tu.AssertEqualsString(t, "logs-2023.01.02\nlogs-2023.01.03\nlogs-archive\n", out.String())
+
+	out.Reset()
+	writeIndices(&out, filterIndicesForDateRange(rows, "", "2023-01-01"), false, true)
+	tu.AssertEqualsString(t, "logs-2023.01.01 10 1mb\nlogs-archive 5 5kb\n", out.String())
+
+	out.Reset()
+	writeIndices(&out, rows[1:2], true, false)
+	tu.AssertEqualsString(t, "{\"index\":\"logs-2023.01.01\"}\n", out.String())
+	out.Reset()
+	writeIndices(&out, rows[1:2], true, true)
+	tu.AssertEqualsString(t, "{\"index\":\"logs-2023.01.01\",\"docs_count\":10,\"store_size\":\"1mb\"}\n", out.String())
+}
+
+func TestReadTerms(t *testing.T) {
+	terms, err := readTerms(strings.NewReader("# known error signatures\nOutOfMemoryError\n\n  connection reset  \n#disabled\nstatus:503\n"))
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, "OutOfMemoryError|connection reset|status:503", strings.Join(terms, "|"))
+	tu.AssertEqualsString(t, "(OutOfMemoryError) AND (connection reset)", strings.Join(andTerms(nil, terms[:2]), " "))
+	tu.AssertEqualsString(t, "level:error AND (status:503)", strings.Join(andTerms([]string{"level:error"}, terms[2:]), " "))
+
+	saved := []string{"level:error", "AND", "app:api"}
+	combined, _ := combineTerms(saved, []string{"status:503"}, combineAnd)
+	tu.AssertEqualsString(t, "level:error AND app:api AND status:503", strings.Join(combined, " "))
+	combined, _ = combineTerms(saved, []string{"status:503", "OR", "status:504"}, combineOr)
+	tu.AssertEqualsString(t, "(level:error AND app:api) OR (status:503 OR status:504)", strings.Join(combined, " "))
+	combined, _ = combineTerms([]string{"*"}, []string{"status:503"}, combineOr)
+	tu.AssertEqualsString(t, "status:503", strings.Join(combined, " "))
+	if _, err := combineTerms(saved, []string{"status:503"}, "xor"); err == nil {
+		tu.Fail(t, "Expected error for unknown --combine operator")
+	}
+}
+
+func TestReadQuery(t *testing.T) {
+	query, err := readQuery(strings.NewReader("level:error AND\n  service:api\n"))
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsString(t, "level:error AND service:api", query)
+	if _, err := readQuery(strings.NewReader(" \n")); err == nil {
+		tu.Fail(t, "Expected error for empty query")
+	}
+}
+
+func TestPrintCount(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, _ := ioutil.ReadAll(r.Body)
+		body = string(request)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"responses":[{"status":200,"hits":{"total":{"value":42,"relation":"eq"},"hits":[]}}]}`))
+	}))
+	defer server.Close()
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail := &Tail{client: client, indices: []string{"logs-*"}, terminateAfter: 100,
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"}}
+	var out bytes.Buffer
+	count, err := tail.PrintCount(&out)
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsInt(t, 42, int(count))
+	tu.AssertEqualsString(t, "42\n", out.String())
+	if !strings.Contains(body, `"terminate_after":100`) || !strings.Contains(body, `"size":0`) {
+		tu.Fail(t, "Expected size 0 and terminate_after in the search: "+body)
+	}
+}
+
+func TestDecodeFields(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte("compressed message"))
+	writer.Close()
+	entry := map[string]interface{}{
+		"message": base64.StdEncoding.EncodeToString([]byte("plain message")),
+		"event":   map[string]interface{}{"original": base64.StdEncoding.EncodeToString(compressed.Bytes())},
+		"broken":  "not base64!",
+	}
+	decoders, err := ParseDecoders([]string{"message:base64", "event.original:gzip", "broken:gzip", "missing:base64"})
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	decodeFields(entry, decoders)
+	tu.AssertEqualsString(t, "plain message", entry["message"].(string))
+	tu.AssertEqualsString(t, "compressed message", entry["event"].(map[string]interface{})["original"].(string))
+	tu.AssertEqualsString(t, "not base64!", entry["broken"].(string))
+
+	if _, err := ParseDecoders([]string{"message:rot13"}); err == nil {
+		tu.Fail(t, "Expected error for unsupported encoding")
+	}
+}
+
+func TestSortField(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	sortField, err := ParseSortField("event.duration")
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tail := &Tail{sortField: sortField, order: true,
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"}}
+	source, err := tail.buildInitialSearchRequest(10).Body()
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	if !strings.Contains(source, `"sort":[{"event.duration":{"missing":"_last","order":"desc","unmapped_type":"long"}},{"@timestamp":{"order":"asc"}}]`) {
+		tu.Fail(t, "Expected sort by field with timestamp tiebreaker: "+source)
+	}
+
+	sortField, _ = ParseSortField("user.name:asc:keyword")
+	sortSource, _ := sortField.Source()
+	sortJs, _ := json.Marshal(sortSource)
+	tu.AssertEqualsString(t, `{"user.name":{"missing":"_last","order":"asc","unmapped_type":"keyword"}}`, string(sortJs))
+
+	for _, invalid := range []string{":desc", "duration:down", "a:asc:long:x"} {
+		if _, err := ParseSortField(invalid); err == nil {
+			tu.Fail(t, "Expected error for --sort "+invalid)
+		}
+	}
+}
+
+func TestFormatSearchTiming(t *testing.T) {
+	result := &elastic.SearchResult{TookInMillis: 12, Hits: &elastic.SearchHits{Hits: make([]*elastic.SearchHit, 50)}}
+	tu.AssertEqualsString(t, "Query took 12ms server / 85ms wall, 50 hits", formatSearchTiming(result, 85*time.Millisecond))
+	tu.AssertEqualsString(t, "Query took 3ms server / 4ms wall, 0 hits",
+		formatSearchTiming(&elastic.SearchResult{TookInMillis: 3}, 4*time.Millisecond))
+}
+
+func TestTruncationMessage(t *testing.T) {
+	full := &elastic.SearchResult{Hits: &elastic.SearchHits{
+		TotalHits: &elastic.TotalHits{Value: 12000, Relation: "eq"},
+		Hits:      make([]*elastic.SearchHit, 100),
+	}}
+	tu.AssertEqualsString(t, "Poll returned 100 entries (the --batch-size limit) out of 12000 matching, some entries were "+
+		"probably skipped. Increase --batch-size or narrow down the query.", truncationMessage(full, 100))
+	tu.AssertEqualsString(t, "", truncationMessage(full, 101))
+	tu.AssertEqualsString(t, "", truncationMessage(&elastic.SearchResult{}, 100))
+	if validateTruncation("fail") != nil || validateTruncation("abort") == nil {
+		tu.Fail(t, "Expected only warn, fail and ignore to be valid")
+	}
+}
+
+func TestParseNamedQueries(t *testing.T) {
+	queries, err := parseNamedQueries([]byte(`[{"name": "errors", "query": "level:ERROR", "color": "red"}, {"name": "deploys", "index": "deploy-*"}]`))
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsInt(t, 2, len(queries))
+	tu.AssertEqualsString(t, "deploy-*", queries[1].Index)
+	for _, invalid := range []string{`[]`, `[{"query": "x"}]`, `[{"name": "a"}, {"name": "a"}]`, `[{"name": "a", "color": "pink"}]`} {
+		if _, err := parseNamedQueries([]byte(invalid)); err == nil {
+			tu.Fail(t, "Expected error for "+invalid)
+		}
+	}
+	tu.AssertEqualsString(t, "[errors] ", formatLabel("errors", "red", false))
+	tu.AssertEqualsString(t, ansiRed+"[errors]"+ansiReset+" ", formatLabel("errors", "red", true))
+}
+
+func TestMultiTailProcessResults(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	var buffer bytes.Buffer
+	output := bufio.NewWriter(&buffer)
+	queryDefinition := &configuration.QueryDefinition{TimestampField: "@timestamp", Format: "%message"}
+	multi := &MultiTail{tails: []*Tail{
+		{queryDefinition: queryDefinition, output: output, label: "[a] ", order: true},
+		{queryDefinition: queryDefinition, output: output, label: "[b] "},
+	}}
+	hit := func(millis float64, message string) *elastic.SearchHit {
+		source := fmt.Sprintf(`{"@timestamp": "2022-01-01T00:00:0%.0f.000Z", "message": "%s"}`, millis/1000, message)
+		return &elastic.SearchHit{Sort: []interface{}{millis}, Source: []byte(source)}
+	}
+	multi.processResults([]*elastic.SearchResult{
+		newSearchResult([]*elastic.SearchHit{hit(1000, "a1"), hit(4000, "a4")}, 2),
+		//descending, as fetched by the initial search
+		newSearchResult([]*elastic.SearchHit{hit(3000, "b3"), hit(2000, "b2")}, 2),
+	})
+	tu.AssertEqualsString(t, "[a] a1\n[b] b2\n[b] b3\n[a] a4\n", buffer.String())
+	tu.AssertEqualsString(t, "2022-01-01T00:00:03.000Z", multi.tails[1].lastTimeStamp)
+}
+
+func TestHeartbeatMessage(t *testing.T) {
+	beat := newHeartbeat(time.Minute)
+	now := beat.lastPoll.Add(90 * time.Second)
+	tu.AssertEqualsString(t, "Still tailing, no entries yet, last poll 1m30s ago", beat.message(now))
+	beat.polled(0)
+	if !beat.lastEntry.IsZero() {
+		tu.Fail(t, "Expected poll without new entries not to count as an entry")
+	}
+	beat.polled(3)
+	beat.lastPoll = beat.lastEntry.Add(170 * time.Second)
+	tu.AssertEqualsString(t, "Still tailing, last entry 3m0s ago, last poll 10s ago", beat.message(beat.lastEntry.Add(3*time.Minute)))
+}
+
+func TestThroughputStatsMessage(t *testing.T) {
+	stats := newThroughputStats(10 * time.Second)
+	stats.add(100, 2048)
+	stats.setDelay(time.Second)
+	tu.AssertEqualsString(t, "Stats: 10.0 entries/s, 100 entries total, poll delay 1s, 2.0 KB processed",
+		stats.message(stats.reported.Add(10*time.Second)))
+	stats.reported = stats.reported.Add(10 * time.Second)
+	stats.reportedCount = stats.entries
+	stats.add(25, 3*1024*1024)
+	tu.AssertEqualsString(t, "Stats: 5.0 entries/s, 125 entries total, poll delay 1s, 3.0 MB processed",
+		stats.message(stats.reported.Add(5*time.Second)))
+	tu.AssertEqualsString(t, "512 B", formatBytes(512))
+}
+
+func TestPagedSearchTiebreaker(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/_msearch" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, string(body))
+		w.Write([]byte(`{"responses":[{"status":200,"hits":{"total":{"value":2,"relation":"eq"},` +
+			`"hits":[{"_id":"1","_source":{"@timestamp":"2022-01-01T00:00:01.000Z","message":"first"},"sort":[1,5]},` +
+			`{"_id":"2","_source":{"@timestamp":"2022-01-01T00:00:01.000Z","message":"second"},"sort":[1,7]}]}}]}`))
+	}))
+	defer server.Close()
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	tail := &Tail{client: client, indices: []string{"logs-*"}, output: bufio.NewWriter(&out), order: true,
+		dedupIdField: "event.id", queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp",
+			Format: "%message"}}
+	if err := tail.pagedSearch(2); err != nil {
+		t.Fatal(err)
+	}
+	tu.AssertEqualsString(t, "first\nsecond\n", out.String())
+	if !strings.Contains(requests[0], `"sort":[{"@timestamp":{"order":"asc"}},{"event.id":{"order":"asc"}}]`) {
+		tu.Fail(t, "Expected search tiebroken by the id field: "+requests[0])
+	}
+
+	//without an id field, entries are tiebroken by _doc
+	tail.dedupIdField = ""
+	if err := tail.pagedSearch(2); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(requests[1], `"sort":[{"@timestamp":{"order":"asc"}},{"_doc":{"order":"asc"}}]`) {
+		tu.Fail(t, "Expected search tiebroken by _doc: "+requests[1])
+	}
+}
+
+func TestStartMaxIterations(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	searches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.URL.Path, "_msearch") {
+			w.Write([]byte(`{}`))
+			return
+		}
+		searches++
+		//initial search finds the first entry, the single poll the second one
+		source := fmt.Sprintf(`{"@timestamp":"2022-01-01T00:00:0%d.000Z","message":"entry %d"}`, searches, searches)
+		w.Write([]byte(`{"responses":[{"status":200,"hits":{"total":{"value":1,"relation":"eq"},` +
+			`"hits":[{"_id":"` + strconv.Itoa(searches) + `","_source":` + source + `}]}}]}`))
+	}))
+	defer server.Close()
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	tail := &Tail{client: client, indices: []string{"logs-*"}, output: bufio.NewWriter(&out), batchSize: 100,
+		maxIterations: 1, queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp", Format: "%message"}}
+	tail.Start(true, 10)
+	tu.AssertEqualsInt(t, 2, searches)
+	tu.AssertEqualsString(t, "entry 1\nentry 2\n", out.String())
+}
+
+func TestAllowMissing(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		header = strings.SplitN(string(body), "\n", 2)[0]
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"responses":[{"status":404,"error":{"type":"index_not_found_exception",` +
+			`"reason":"no such index [logs-2022.01.02]","index":"logs-2022.01.02"}}]}`))
+	}))
+	defer server.Close()
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tail := &Tail{client: client, indices: []string{"logs-2022.01.02"},
+		queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"}}
+	_, err = tail.executeSearch(tail.indices, tail.newSearchRequest())
+	if !isIndexNotFound(err) || !strings.Contains(describeSearchError(err), "--allow-missing") {
+		tu.Fail(t, "Expected index not found error suggesting --allow-missing")
+	}
+
+	tail.allowMissing = true
+	result, err := tail.executeSearch(tail.indices, tail.newSearchRequest())
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsInt(t, 0, len(result.Hits.Hits))
+	if !strings.Contains(header, `"ignore_unavailable":true`) || !strings.Contains(header, `"allow_no_indices":true`) {
+		tu.Fail(t, "Expected missing indices to be allowed in the request header: "+header)
+	}
+}
+
+func TestCoerceFields(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	entry, _ := parseEntry([]byte(`{"count": 5.0, "big": 9007199254740993, "ratio": "0.25", "ok": "true",
+		"flag": 1, "status": 200, "labels": {"app": "api"}, "name": "x"}`))
+	coercions, err := ParseCoercions([]string{"count:int", "big:int", "ratio:float", "ok:bool", "flag:bool",
+		"status:string", "labels:string", "name:int", "missing:int"})
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	coerceFields(entry, coercions)
+	line, _ := formatNDJSON(entry, nil, false)
+	tu.AssertEqualsString(t, `{"big":9007199254740993,"count":5,"flag":true,"labels":"{\"app\":\"api\"}","name":"x",`+
+		`"ok":true,"ratio":0.25,"status":"200"}`, line)
+	if _, err := ParseCoercions([]string{"count:long"}); err == nil {
+		tu.Fail(t, "Expected unknown type to be rejected")
+	}
+}
+
+func TestImportKibanaQuery(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	export := `{"attributes":{"title":"errors","query":{"query":"level:ERROR","language":"lucene"},"filters":[` +
+		`{"meta":{"type":"phrase","key":"host","negate":false,"params":{"query":"web-1"}},"query":{"match_phrase":{"host":"web-1"}}},` +
+		`{"meta":{"type":"exists","key":"trace.id","negate":true},"exists":{"field":"trace.id"}},` +
+		`{"meta":{"type":"range","key":"status","params":{"gte":500,"lt":600}},"range":{"status":{"gte":500,"lt":600}}},` +
+		`{"meta":{"type":"phrases","key":"env","params":["prod","staging"]}},` +
+		`{"meta":{"type":"phrase","key":"app","disabled":true,"params":{"query":"x"}}},` +
+		`{"meta":{"type":"custom","key":"query"},"query":{"bool":{}}}]},"id":"1","type":"query"}` + "\n" +
+		`{"exportedCount":1,"missingRefCount":0,"missingReferences":[]}` + "\n"
+	imported, err := parseKibanaQuery([]byte(export))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.AssertEqualsString(t, "level:ERROR", imported.Query)
+	tail := &Tail{queryDefinition: &configuration.QueryDefinition{TimestampField: "@timestamp"},
+		importedFilters: kibanaFilterQueries(imported.Filters)}
+	tu.AssertEqualsString(t, `{"bool":{"filter":[{"match_all":{}},{"match_phrase":{"host":{"query":"web-1"}}},`+
+		`{"bool":{"must_not":{"exists":{"field":"trace.id"}}}},`+
+		`{"range":{"status":{"from":500,"include_lower":true,"include_upper":false,"to":600}}},`+
+		`{"bool":{"minimum_should_match":"1","should":[{"match_phrase":{"env":{"query":"prod"}}},{"match_phrase":{"env":{"query":"staging"}}}]}}]}}`,
+		querySource(t, tail.buildSearchQuery()))
+
+	imported, err = parseKibanaQuery([]byte(`[{"meta":{"type":"exists","key":"error"}}]`))
+	if err != nil || len(imported.Filters) != 1 || imported.Query != "" {
+		tu.Fail(t, "Expected array of filters to be imported")
+	}
+	if _, err := parseKibanaQuery([]byte(`{"exportedCount":0}`)); err == nil {
+		tu.Fail(t, "Expected error for export without saved query")
+	}
 }
@@ -7,6 +7,7 @@ package main
 
 import (
 	"github.com/piersharding/elktail/testutils"
+	"strings"
 	"testing"
 )
 
@@ -24,7 +25,151 @@ func TestResolveField(t *testing.T) {
 	testutils.AssertEqualsString(t, "", eval(model1, "bar"))
 }
 
+func TestResolveFieldBigInteger(t *testing.T) {
+	entry, err := parseEntry([]byte(`{"id":1234567890123456789,"span":{"id":9223372036854775807},"ratio":0.25}`))
+	if err != nil {
+		testutils.Fail(t, err.Error())
+	}
+	testutils.AssertEqualsString(t, "1234567890123456789", eval(entry, "id"))
+	testutils.AssertEqualsString(t, "9223372036854775807", eval(entry, "span.id"))
+	testutils.AssertEqualsString(t, "0.25", eval(entry, "ratio"))
+}
+
+func TestResolveIndexPattern(t *testing.T) {
+	lookupEnv := func(name string) (string, bool) {
+		if name == "REGION" {
+			return "eu", true
+		}
+		return "", false
+	}
+	resolved, _ := ResolveIndexPattern("app-{env}-{REGION}-*", "prod", lookupEnv)
+	testutils.AssertEqualsString(t, "app-prod-eu-*", resolved)
+	resolved, _ = ResolveIndexPattern("logstash-[0-9].*", "", lookupEnv)
+	testutils.AssertEqualsString(t, "logstash-[0-9].*", resolved)
+	resolved, _ = ResolveIndexPattern("<app-{env}-{now/d}>", "prod", lookupEnv)
+	testutils.AssertEqualsString(t, "<app-prod-{now/d}>", resolved)
+	if _, err := ResolveIndexPattern("app-{env}-*", "", lookupEnv); err == nil {
+		testutils.Fail(t, "Expected error for {env} without --env")
+	}
+	if _, err := ResolveIndexPattern("app-{ZONE}-*", "prod", lookupEnv); err == nil {
+		testutils.Fail(t, "Expected error for unset environment variable")
+	}
+}
+
 func eval(model interface{}, expr string) string {
 	result, _ := EvaluateExpression(model, expr)
 	return result
 }
+
+func TestReadSecret(t *testing.T) {
+	secret, _ := readSecret(strings.NewReader("s3cr3t\n"))
+	testutils.AssertEqualsString(t, "s3cr3t", secret)
+	secret, _ = readSecret(strings.NewReader("s3cr3t\r\n"))
+	testutils.AssertEqualsString(t, "s3cr3t", secret)
+	secret, _ = readSecret(strings.NewReader("pass word"))
+	testutils.AssertEqualsString(t, "pass word", secret)
+}
+
+func TestParseQueryParams(t *testing.T) {
+	params, err := ParseQueryParams([]string{"ignore_throttled=false", "preference = _local"})
+	if err != nil {
+		testutils.Fail(t, err.Error())
+	}
+	testutils.AssertEqualsString(t, "false", params.Get("ignore_throttled"))
+	testutils.AssertEqualsString(t, "_local", params.Get("preference"))
+
+	_, err = ParseQueryParams([]string{"ignore_throttled"})
+	if err == nil {
+		testutils.Fail(t, "Expected error for parameter without value")
+	}
+}
+
+func TestParseRuntimeFields(t *testing.T) {
+	mappings, names, err := ParseRuntimeFields([]string{"day:keyword:emit(doc['@timestamp'].value.dayOfWeekEnum.toString())"})
+	if err != nil {
+		testutils.Fail(t, err.Error())
+	}
+	testutils.AssertEqualsInt(t, 1, len(names))
+	testutils.AssertEqualsString(t, "day", names[0])
+	mapping := mappings["day"].(map[string]interface{})
+	testutils.AssertEqualsString(t, "keyword", mapping["type"].(string))
+	script := mapping["script"].(map[string]interface{})
+	testutils.AssertEqualsString(t, "emit(doc['@timestamp'].value.dayOfWeekEnum.toString())", script["source"].(string))
+
+	_, _, err = ParseRuntimeFields([]string{"day:keyword"})
+	if err == nil {
+		testutils.Fail(t, "Expected error for runtime field without script")
+	}
+}
+
+func TestEvaluateJSONPath(t *testing.T) {
+	model := map[string]interface{}{
+		"kubernetes": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "sidecar", "image": "envoy:1.0"},
+				map[string]interface{}{"name": "app", "image": "app:2.1"},
+			},
+		},
+		"tags": []interface{}{"a", "b"},
+	}
+	testutils.AssertEqualsString(t, "app:2.1", jsonPath(model, "$.kubernetes.containers[?(@.name=='app')].image"))
+	testutils.AssertEqualsString(t, "envoy:1.0", jsonPath(model, "$.kubernetes.containers[?(@.name!='app')].image"))
+	testutils.AssertEqualsString(t, "envoy:1.0", jsonPath(model, "$['kubernetes']['containers'][0]['image']"))
+	testutils.AssertEqualsString(t, "b", jsonPath(model, "$.tags[-1]"))
+	testutils.AssertEqualsString(t, "a,b", jsonPath(model, "$.tags[*]"))
+	testutils.AssertEqualsString(t, "sidecar,app", jsonPath(model, "$.kubernetes.containers[*].name"))
+	testutils.AssertEqualsString(t, "", jsonPath(model, "$.kubernetes.containers[?(@.name=='db')].image"))
+	testutils.AssertEqualsString(t, "", jsonPath(model, "$.tags[5]"))
+	_, err := EvaluateJSONPath(model, "$.tags[x")
+	if err == nil {
+		testutils.Fail(t, "Expected error for unterminated [")
+	}
+}
+
+func TestEvaluateFormatField(t *testing.T) {
+	model := map[string]interface{}{
+		"map":  map[string]interface{}{"test": "test"},
+		"list": []interface{}{"first"},
+	}
+	value, _ := evaluateFormatField(model, "%map.test")
+	testutils.AssertEqualsString(t, "test", value)
+	value, _ = evaluateFormatField(model, "%{$.list[0]}")
+	testutils.AssertEqualsString(t, "first", value)
+	testutils.AssertEqualsString(t, "%{$.list[0]}", formatRegexp.FindString("x %{$.list[0]} y"))
+}
+
+func jsonPath(model interface{}, expr string) string {
+	result, _ := EvaluateJSONPath(model, expr)
+	return result
+}
+
+func TestResolveProxyURL(t *testing.T) {
+	proxyURL, err := ResolveProxyURL("", "_msearch")
+	if err != nil {
+		testutils.Fail(t, err.Error())
+	}
+	testutils.AssertEqualsString(t, "/elasticsearch/_msearch", proxyURL.Path)
+	testutils.AssertEqualsString(t, "", proxyURL.RawQuery)
+
+	proxyURL, err = ResolveProxyURL("/api/console/proxy?path={path}&method=POST", "_msearch")
+	if err != nil {
+		testutils.Fail(t, err.Error())
+	}
+	testutils.AssertEqualsString(t, "/api/console/proxy", proxyURL.Path)
+	testutils.AssertEqualsString(t, "_msearch", proxyURL.Query().Get("path"))
+	testutils.AssertEqualsString(t, "POST", proxyURL.Query().Get("method"))
+
+	_, err = ResolveProxyURL("elasticsearch/{path}", "_msearch")
+	if err == nil {
+		testutils.Fail(t, "Expected error for relative proxy path")
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	lines := readLines(strings.NewReader("\nnext\n"))
+	testutils.AssertEqualsString(t, "", <-lines)
+	testutils.AssertEqualsString(t, "next", <-lines)
+	if _, ok := <-lines; ok {
+		testutils.Fail(t, "Expected channel to be closed at the end of input")
+	}
+}
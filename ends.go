@@ -0,0 +1,56 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// PrintEnds prints the first and the last count entries matching the query (and date range), like head and tail
+// combined, with the number of entries omitted between them
+func (tail *Tail) PrintEnds(count int) error {
+	order := tail.order
+	defer func() { tail.order = order }()
+
+	tail.order = true
+	first, err := tail.executeSearch(tail.indices, tail.buildInitialSearchRequest(count).TrackTotalHits(true))
+	if err != nil {
+		return err
+	}
+	tail.order = false
+	last, err := tail.executeSearch(tail.indices, tail.buildInitialSearchRequest(count).TrackTotalHits(true))
+	if err != nil {
+		return err
+	}
+
+	lastHits, omitted := splitEnds(first.Hits.Hits, last.Hits.Hits, first.TotalHits())
+	tail.order = true
+	tail.processResults(first)
+	if omitted > 0 {
+		fmt.Fprintf(tail.output, "... %d entries omitted ...\n", omitted)
+	}
+	tail.order = false
+	tail.processResults(newSearchResult(lastHits, int64(len(lastHits))))
+	return nil
+}
+
+// Returns the last hits (in descending order) that are not among the first ones, which happens when fewer than twice
+// the count entries match, and the number of matching entries that are in neither
+func splitEnds(first []*elastic.SearchHit, last []*elastic.SearchHit, total int64) ([]*elastic.SearchHit, int64) {
+	printed := make(map[string]bool, len(first))
+	for _, hit := range first {
+		printed[hit.Index+"/"+hit.Id] = true
+	}
+	var lastHits []*elastic.SearchHit
+	for _, hit := range last {
+		if !printed[hit.Index+"/"+hit.Id] {
+			lastHits = append(lastHits, hit)
+		}
+	}
+	return lastHits, total - int64(len(first)) - int64(len(lastHits))
+}
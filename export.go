@@ -0,0 +1,162 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/net/context"
+)
+
+const exportPageSize = 1000
+const exportKeepAlive = "5m"
+const progressInterval = 500 * time.Millisecond
+
+type bulkAction struct {
+	Index bulkActionMetadata `json:"index"`
+}
+
+type bulkActionMetadata struct {
+	Index string `json:"_index"`
+	Id    string `json:"_id"`
+}
+
+// Export scrolls through all the entries matching the query (and date range) and writes them to the file
+// in elasticsearch _bulk format, so they can be replayed into another cluster with
+// curl -H 'Content-Type: application/x-ndjson' --data-binary @file http://host:9200/_bulk
+// With --reindex-format only the sources are written, one per line, restricted to the --fields.
+func (tail *Tail) Export(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+
+	scroll := tail.client.Scroll(tail.indices...).
+		KeepAlive(exportKeepAlive).
+		Query(tail.buildSearchQuery()).
+		Size(exportPageSize)
+	if tail.unordered {
+		//_doc order doesn't need sorting, so it's the fastest way to scroll through all the entries
+		scroll = scroll.Sort("_doc", true)
+	} else {
+		scroll = scroll.Sort(tail.queryDefinition.TimestampField, true)
+	}
+	if tail.preference != "" {
+		scroll = scroll.Preference(tail.preference)
+	}
+	writeEntry := writeBulkEntry
+	if tail.reindexFormat {
+		if len(tail.fields) > 0 {
+			//projected by elasticsearch, so the documents keep their structure
+			scroll = scroll.FetchSourceContext(elastic.NewFetchSourceContext(true).Include(tail.fields...))
+		}
+		writeEntry = writeSourceEntry
+	}
+	defer scroll.Clear(context.Background())
+
+	exported := 0
+	progress := newExportProgress(isTerminal(os.Stderr))
+	defer progress.finish()
+	for {
+		result, err := scroll.Do(context.Background())
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		for _, hit := range result.Hits.Hits {
+			if err := writeEntry(writer, hit); err != nil {
+				return err
+			}
+		}
+		exported += len(result.Hits.Hits)
+		Trace.Printf("Exported %d out of %d entries.\n", exported, result.TotalHits())
+		progress.update(exported, result.TotalHits())
+	}
+	Info.Printf("Exported %d entries to %s\n", exported, path)
+	return writer.Flush()
+}
+
+// Writes the hit as _bulk index action line followed by the document source line
+func writeBulkEntry(writer io.Writer, hit *elastic.SearchHit) error {
+	action, err := json.Marshal(bulkAction{Index: bulkActionMetadata{Index: hit.Index, Id: hit.Id}})
+	if err != nil {
+		return err
+	}
+	var source bytes.Buffer
+	if err := json.Compact(&source, hit.Source); err != nil {
+		return err
+	}
+	action = append(action, '\n')
+	if _, err := writer.Write(action); err != nil {
+		return err
+	}
+	source.WriteByte('\n')
+	_, err = writer.Write(source.Bytes())
+	return err
+}
+
+// Writes the document source of the hit as a single line
+func writeSourceEntry(writer io.Writer, hit *elastic.SearchHit) error {
+	var source bytes.Buffer
+	if err := json.Compact(&source, hit.Source); err != nil {
+		return err
+	}
+	source.WriteByte('\n')
+	_, err := writer.Write(source.Bytes())
+	return err
+}
+
+// Reports progress of the export on stderr, so it doesn't mix with exported data. Progress line is rewritten in place,
+// so it's only shown on terminals.
+type exportProgress struct {
+	enabled    bool
+	started    time.Time
+	lastUpdate time.Time
+	printed    bool
+}
+
+func newExportProgress(enabled bool) *exportProgress {
+	return &exportProgress{enabled: enabled, started: time.Now()}
+}
+
+func (progress *exportProgress) update(exported int, total int64) {
+	if !progress.enabled || time.Since(progress.lastUpdate) < progressInterval {
+		return
+	}
+	progress.lastUpdate = time.Now()
+	fmt.Fprint(os.Stderr, "\r"+formatProgress(exported, total, time.Since(progress.started)))
+	progress.printed = true
+}
+
+func (progress *exportProgress) finish() {
+	if progress.printed {
+		fmt.Fprintln(os.Stderr)
+	}
+}
+
+// Formats progress line, e.g. "Exported 1500/6000 entries (25%), 300 entries/s"
+func formatProgress(exported int, total int64, elapsed time.Duration) string {
+	percentage := int64(100)
+	if total > 0 {
+		percentage = int64(exported) * 100 / total
+	}
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(exported) / elapsed.Seconds()
+	}
+	return fmt.Sprintf("Exported %d/%d entries (%d%%), %.0f entries/s", exported, total, percentage, rate)
+}
@@ -0,0 +1,71 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// heartbeat prints a status line when no new entries were printed for the interval (--heartbeat), so a quiet
+// follow session can be told apart from a hung one. It runs on its own timer, as polls may be far apart (e.g. with
+// --poll-on-demand).
+type heartbeat struct {
+	mutex        sync.Mutex
+	interval     time.Duration
+	printed      int64     //entries printed as of the last poll
+	lastEntry    time.Time //when new entries were last printed, zero if none were
+	lastPoll     time.Time //when the last poll succeeded
+	lastActivity time.Time //when new entries or the last heartbeat were printed
+}
+
+func newHeartbeat(interval time.Duration) *heartbeat {
+	now := time.Now()
+	return &heartbeat{interval: interval, lastPoll: now, lastActivity: now}
+}
+
+// Records a successful poll, given the number of entries printed so far
+func (beat *heartbeat) polled(printed int64) {
+	beat.mutex.Lock()
+	defer beat.mutex.Unlock()
+	now := time.Now()
+	beat.lastPoll = now
+	if printed != beat.printed {
+		beat.printed = printed
+		beat.lastEntry = now
+		beat.lastActivity = now
+	}
+}
+
+// Prints the status line to out whenever nothing was printed for the interval
+func (beat *heartbeat) run(out io.Writer) {
+	for {
+		beat.mutex.Lock()
+		wait := time.Until(beat.lastActivity.Add(beat.interval))
+		beat.mutex.Unlock()
+		if wait > 0 {
+			time.Sleep(wait)
+			continue
+		}
+		beat.mutex.Lock()
+		now := time.Now()
+		message := beat.message(now)
+		beat.lastActivity = now
+		beat.mutex.Unlock()
+		fmt.Fprintln(out, message)
+	}
+}
+
+// Formats the status line, e.g. "Still tailing, last entry 3m0s ago, last poll 1s ago"
+func (beat *heartbeat) message(now time.Time) string {
+	lastEntry := "no entries yet"
+	if !beat.lastEntry.IsZero() {
+		lastEntry = fmt.Sprintf("last entry %s ago", now.Sub(beat.lastEntry).Round(time.Second))
+	}
+	return fmt.Sprintf("Still tailing, %s, last poll %s ago", lastEntry, now.Sub(beat.lastPoll).Round(time.Second))
+}
@@ -0,0 +1,74 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+const histogramAggregation = "histogram"
+const histogramBarWidth = 60
+
+// PrintHistogram runs a date histogram aggregation over the entries matching the query (and date range) and
+// prints an ASCII bar chart of entry counts per time bucket. Interval uses elasticsearch time units, e.g. 1m or 1h.
+func (tail *Tail) PrintHistogram(interval string) error {
+	aggregation := elastic.NewDateHistogramAggregation().
+		Field(tail.queryDefinition.TimestampField).
+		FixedInterval(interval).
+		MinDocCount(0)
+	//make sure that empty buckets at the start and the end of date range are also shown
+	if tail.queryDefinition.AfterDateTime != "" {
+		aggregation = aggregation.ExtendedBoundsMin(tail.queryDefinition.AfterDateTime)
+	}
+	if tail.queryDefinition.BeforeDateTime != "" {
+		aggregation = aggregation.ExtendedBoundsMax(tail.queryDefinition.BeforeDateTime)
+	}
+	searchRequest := tail.newSearchRequest().
+		Query(tail.buildSearchQuery()).
+		Size(0).
+		Aggregation(histogramAggregation, aggregation)
+
+	result, err := tail.executeSearch(tail.indices, searchRequest)
+	if err != nil {
+		return err
+	}
+	histogram, found := result.Aggregations.DateHistogram(histogramAggregation)
+	if !found {
+		return fmt.Errorf("no histogram in search response")
+	}
+
+	var maxCount int64
+	for _, bucket := range histogram.Buckets {
+		if bucket.DocCount > maxCount {
+			maxCount = bucket.DocCount
+		}
+	}
+	for _, bucket := range histogram.Buckets {
+		key := formatElasticTimeStamp(time.Unix(0, int64(bucket.Key)*int64(time.Millisecond)).UTC())
+		if bucket.KeyAsString != nil {
+			key = *bucket.KeyAsString
+		}
+		fmt.Printf("%s %10d %s\n", key, bucket.DocCount, histogramBar(bucket.DocCount, maxCount, histogramBarWidth))
+	}
+	return nil
+}
+
+// Renders a bar proportional to count, where maxCount fills the whole width. Non zero counts always get at
+// least one character so they can be told apart from empty buckets.
+func histogramBar(count, maxCount int64, width int) string {
+	if maxCount == 0 || count == 0 {
+		return ""
+	}
+	length := int(count * int64(width) / maxCount)
+	if length == 0 {
+		length = 1
+	}
+	return strings.Repeat("#", length)
+}
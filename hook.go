@@ -0,0 +1,55 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+)
+
+// Environment variables passing the reconnect context to the --on-reconnect command
+const (
+	reconnectServerVar   = "ELKTAIL_RECONNECT_SERVER"
+	reconnectAttemptsVar = "ELKTAIL_RECONNECT_ATTEMPTS"
+	reconnectErrorVar    = "ELKTAIL_RECONNECT_ERROR"
+)
+
+// Runs the --on-reconnect command with the shell in the background, so the tailing is not held up by it. Output of
+// the command goes to stderr, so it doesn't mix with the entries.
+func runReconnectHook(command string, server string, attempts int, err error) {
+	cmd := reconnectHookCommand(command, server, attempts, err)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		Error.Printf("Failed running --on-reconnect command: %s\n", err)
+		return
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			Error.Printf("The --on-reconnect command failed: %s\n", err)
+		}
+	}()
+}
+
+func reconnectHookCommand(command string, server string, attempts int, err error) *exec.Cmd {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+	}
+	cmd.Env = append(os.Environ(),
+		reconnectServerVar+"="+server,
+		reconnectAttemptsVar+"="+strconv.Itoa(attempts),
+		reconnectErrorVar+"="+lastError)
+	return cmd
+}
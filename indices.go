@@ -0,0 +1,92 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/net/context"
+)
+
+// Index listed by --list-indices, counts and sizes are only included in detailed listing
+type listedIndex struct {
+	Index     string `json:"index"`
+	DocsCount *int   `json:"docs_count,omitempty"`
+	StoreSize string `json:"store_size,omitempty"`
+}
+
+// ListIndices prints the indices the index pattern resolves to (narrowed down to the date range by the dates in
+// their names, if given), one per line or as JSON objects in ndjson output. Detailed listing includes document
+// counts and sizes of the indices.
+func (tail *Tail) ListIndices(out io.Writer, detailed bool) error {
+	rows, err := tail.client.CatIndices().Index(strings.Join(tail.indices, ",")).Do(context.TODO())
+	if err != nil {
+		return err
+	}
+	if tail.queryDefinition.IsDateTimeFiltered() {
+		rows = filterIndicesForDateRange(rows, tail.queryDefinition.AfterDateTime, tail.queryDefinition.BeforeDateTime)
+	}
+	return writeIndices(out, rows, tail.ndjson, detailed)
+}
+
+// Date in index names, e.g. 2023.01.31 in filebeat-7.17.0-2023.01.31
+var indexDateRegexp = regexp.MustCompile(`\d{4}\.\d{2}\.\d{2}`)
+
+// Keeps the indices whose names contain a date within the range (dates are compared by day). Indices without
+// a date in their name can't be told apart by it, so they are kept.
+func filterIndicesForDateRange(rows elastic.CatIndicesResponse, after string, before string) elastic.CatIndicesResponse {
+	var start, end time.Time
+	if after != "" {
+		start = extractYMDDate(after, "-")
+	}
+	if before != "" {
+		end = extractYMDDate(before, "-")
+	}
+	var result elastic.CatIndicesResponse
+	for _, row := range rows {
+		date, err := time.Parse("2006.01.02", indexDateRegexp.FindString(row.Index))
+		if err == nil && (date.Before(start) || !end.IsZero() && date.After(end)) {
+			continue
+		}
+		result = append(result, row)
+	}
+	return result
+}
+
+// Writes the indices sorted by name
+func writeIndices(out io.Writer, rows elastic.CatIndicesResponse, asJSON bool, detailed bool) error {
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Index < rows[j].Index })
+	for _, row := range rows {
+		index := listedIndex{Index: row.Index}
+		if detailed {
+			docsCount := row.DocsCount
+			index.DocsCount, index.StoreSize = &docsCount, row.StoreSize
+		}
+		var err error
+		switch {
+		case asJSON:
+			var line []byte
+			if line, err = json.Marshal(index); err == nil {
+				_, err = fmt.Fprintln(out, string(line))
+			}
+		case detailed:
+			_, err = fmt.Fprintf(out, "%s %d %s\n", index.Index, row.DocsCount, index.StoreSize)
+		default:
+			_, err = fmt.Fprintln(out, index.Index)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
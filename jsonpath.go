@@ -0,0 +1,199 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Supported subset of JSONPath:
+// $.foo.bar or $['foo']['bar']  - child keys
+// $.foo[0], $.foo[-1]           - array elements (negative index counts from the end)
+// $.foo[*], $.foo.*             - all array elements or map values
+// $.foo[?(@.name=='app')]       - array elements (or map values) for which the filter holds, == and != are supported
+//                                 and @ path uses dot syntax
+
+type jsonPathStepKind int
+
+const (
+	jsonPathKey jsonPathStepKind = iota
+	jsonPathIndex
+	jsonPathWildcard
+	jsonPathFilter
+)
+
+type jsonPathStep struct {
+	kind  jsonPathStepKind
+	key   string
+	index int
+	// filter: @.<key> <operator> <value>
+	operator string
+	value    string
+}
+
+// EvaluateJSONPath evaluates a JSONPath expression (see supported subset above) on the model. If the expression
+// matches several values, they are joined with comma. If it matches nothing, function returns empty string and
+// an error.
+func EvaluateJSONPath(model interface{}, expression string) (string, error) {
+	steps, err := parseJSONPath(expression)
+	if err != nil {
+		return "", err
+	}
+	current := []interface{}{model}
+	for _, step := range steps {
+		var next []interface{}
+		for _, value := range current {
+			next = append(next, step.apply(value)...)
+		}
+		current = next
+	}
+	if len(current) == 0 {
+		return "", fmt.Errorf("JSONPath expression %s did not match anything.", expression)
+	}
+	values := make([]string, len(current))
+	for i, value := range current {
+		values[i] = fmt.Sprintf("%v", value)
+	}
+	return strings.Join(values, ","), nil
+}
+
+func parseJSONPath(expression string) ([]jsonPathStep, error) {
+	if !strings.HasPrefix(expression, "$") {
+		return nil, fmt.Errorf("JSONPath expression %s does not start with $", expression)
+	}
+	var steps []jsonPathStep
+	path := expression[1:]
+	for len(path) > 0 {
+		switch {
+		case strings.HasPrefix(path, ".*"):
+			steps = append(steps, jsonPathStep{kind: jsonPathWildcard})
+			path = path[2:]
+		case path[0] == '.':
+			end := strings.IndexAny(path[1:], ".[")
+			if end == -1 {
+				end = len(path) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("JSONPath expression %s has an empty key", expression)
+			}
+			steps = append(steps, jsonPathStep{kind: jsonPathKey, key: path[1 : end+1]})
+			path = path[end+1:]
+		case strings.HasPrefix(path, "[?("):
+			end := strings.Index(path, ")]")
+			if end == -1 {
+				return nil, fmt.Errorf("JSONPath expression %s has unterminated filter", expression)
+			}
+			step, err := parseJSONPathFilter(path[3:end])
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			path = path[end+2:]
+		case path[0] == '[':
+			end := strings.Index(path, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("JSONPath expression %s has unterminated [", expression)
+			}
+			selector := path[1:end]
+			if selector == "*" {
+				steps = append(steps, jsonPathStep{kind: jsonPathWildcard})
+			} else if isQuoted(selector) {
+				steps = append(steps, jsonPathStep{kind: jsonPathKey, key: selector[1 : len(selector)-1]})
+			} else {
+				index, err := strconv.Atoi(selector)
+				if err != nil {
+					return nil, fmt.Errorf("JSONPath expression %s has invalid index %s", expression, selector)
+				}
+				steps = append(steps, jsonPathStep{kind: jsonPathIndex, index: index})
+			}
+			path = path[end+1:]
+		default:
+			return nil, fmt.Errorf("JSONPath expression %s can not be parsed at %s", expression, path)
+		}
+	}
+	return steps, nil
+}
+
+// Parses filter expression in @.<key> <operator> <value> format
+func parseJSONPathFilter(filter string) (jsonPathStep, error) {
+	for _, operator := range []string{"==", "!="} {
+		split := strings.SplitN(filter, operator, 2)
+		if len(split) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(split[0])
+		value := strings.TrimSpace(split[1])
+		if !strings.HasPrefix(key, "@.") {
+			return jsonPathStep{}, fmt.Errorf("JSONPath filter %s must start with @.", filter)
+		}
+		if isQuoted(value) {
+			value = value[1 : len(value)-1]
+		}
+		return jsonPathStep{kind: jsonPathFilter, key: key[2:], operator: operator, value: value}, nil
+	}
+	return jsonPathStep{}, fmt.Errorf("JSONPath filter %s has no supported operator (== or !=)", filter)
+}
+
+func isQuoted(s string) bool {
+	return len(s) >= 2 && (s[0] == '\'' && s[len(s)-1] == '\'' || s[0] == '"' && s[len(s)-1] == '"')
+}
+
+// Applies the step on the value, returning matched values
+func (step jsonPathStep) apply(value interface{}) []interface{} {
+	switch step.kind {
+	case jsonPathKey:
+		if valueMap, ok := value.(map[string]interface{}); ok {
+			if child, ok := valueMap[step.key]; ok && child != nil {
+				return []interface{}{child}
+			}
+		}
+	case jsonPathIndex:
+		if valueArray, ok := value.([]interface{}); ok {
+			index := step.index
+			if index < 0 {
+				index += len(valueArray)
+			}
+			if index >= 0 && index < len(valueArray) {
+				return []interface{}{valueArray[index]}
+			}
+		}
+	case jsonPathWildcard:
+		return children(value)
+	case jsonPathFilter:
+		var matched []interface{}
+		for _, child := range children(value) {
+			childValue, err := EvaluateExpression(child, step.key)
+			if (err == nil && childValue == step.value) == (step.operator == "==") {
+				matched = append(matched, child)
+			}
+		}
+		return matched
+	}
+	return nil
+}
+
+// Returns array elements or map values (ordered by key)
+func children(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		result := make([]interface{}, len(keys))
+		for i, key := range keys {
+			result[i] = v[key]
+		}
+		return result
+	}
+	return nil
+}
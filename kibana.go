@@ -0,0 +1,298 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	configuration "github.com/piersharding/elktail/configuration"
+)
+
+// SavedSearch holds index pattern and query of a Kibana saved search
+type SavedSearch struct {
+	Title        string
+	IndexPattern string
+	Query        string
+	Language     string
+}
+
+type savedObject struct {
+	Attributes struct {
+		Title                 string `json:"title"`
+		KibanaSavedObjectMeta struct {
+			SearchSourceJSON string `json:"searchSourceJSON"`
+		} `json:"kibanaSavedObjectMeta"`
+	} `json:"attributes"`
+	References []struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Id   string `json:"id"`
+	} `json:"references"`
+}
+
+type searchSource struct {
+	Index        string `json:"index"`
+	IndexRefName string `json:"indexRefName"`
+	Query        struct {
+		Query    interface{} `json:"query"`
+		Language string      `json:"language"`
+	} `json:"query"`
+}
+
+// FetchSavedSearch fetches the saved search with given id using Kibana saved objects API and resolves the
+// index pattern it references
+func FetchSavedSearch(config *configuration.Configuration, id string) (*SavedSearch, error) {
+	search := new(savedObject)
+	if err := kibanaGet(config, "/api/saved_objects/search/"+id, search); err != nil {
+		return nil, err
+	}
+	source := new(searchSource)
+	if err := json.Unmarshal([]byte(search.Attributes.KibanaSavedObjectMeta.SearchSourceJSON), source); err != nil {
+		return nil, fmt.Errorf("failed parsing search source of saved search %s: %s", id, err)
+	}
+
+	//Kibana 7+ keeps the index pattern id in references, older versions keep it in search source
+	indexPatternId := source.Index
+	for _, reference := range search.References {
+		if reference.Type == "index-pattern" && (source.IndexRefName == "" || reference.Name == source.IndexRefName) {
+			indexPatternId = reference.Id
+		}
+	}
+	if indexPatternId == "" {
+		return nil, fmt.Errorf("saved search %s does not reference an index pattern", id)
+	}
+	indexPattern := new(savedObject)
+	if err := kibanaGet(config, "/api/saved_objects/index-pattern/"+indexPatternId, indexPattern); err != nil {
+		return nil, err
+	}
+
+	result := &SavedSearch{
+		Title:        search.Attributes.Title,
+		IndexPattern: indexPattern.Attributes.Title,
+		Language:     source.Query.Language,
+	}
+	switch query := source.Query.Query.(type) {
+	case string:
+		result.Query = query
+	case nil:
+	default:
+		//very old Kibana versions keep lucene query as query_string object
+		queryJs, _ := json.Marshal(query)
+		return nil, fmt.Errorf("unsupported query in saved search %s: %s", id, queryJs)
+	}
+	return result, nil
+}
+
+// Executes GET request on Kibana API and decodes JSON response into result. Request goes through the same transport
+// as searches, so it's sent with the TLS configuration, credentials, auth cookie and extra headers.
+func kibanaGet(config *configuration.Configuration, path string, result interface{}) error {
+	url := resolveUrl(config)
+	request, err := http.NewRequest("GET", strings.TrimSuffix(url, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	client, err := newKibanaHTTPClient(config, url)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("kibana returned %s for %s", response.Status, path)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+// KibanaQuery holds query and filters of a saved query exported from Kibana (--import-query)
+type KibanaQuery struct {
+	Query    string
+	Language string
+	Filters  []kibanaFilter
+}
+
+type kibanaSavedQuery struct {
+	Query struct {
+		Query    interface{} `json:"query"`
+		Language string      `json:"language"`
+	} `json:"query"`
+	Filters []kibanaFilter `json:"filters"`
+}
+
+type kibanaFilter struct {
+	Meta struct {
+		Type     string          `json:"type"`
+		Key      string          `json:"key"`
+		Negate   bool            `json:"negate"`
+		Disabled bool            `json:"disabled"`
+		Params   json.RawMessage `json:"params"`
+	} `json:"meta"`
+	Query  map[string]map[string]interface{} `json:"query"`
+	Range  map[string]map[string]interface{} `json:"range"`
+	Exists map[string]interface{}            `json:"exists"`
+}
+
+// LoadKibanaQuery reads the saved query exported from Kibana: saved objects export (ndjson, the first saved query
+// is used), attributes of the saved query or just the array of filters
+func LoadKibanaQuery(path string) (*KibanaQuery, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseKibanaQuery(data)
+}
+
+func parseKibanaQuery(data []byte) (*KibanaQuery, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		saved := new(kibanaSavedQuery)
+		if bytes.HasPrefix(bytes.TrimSpace(value), []byte("[")) {
+			if err := json.Unmarshal(value, &saved.Filters); err != nil {
+				return nil, err
+			}
+			return &KibanaQuery{Filters: saved.Filters}, nil
+		}
+		object := struct {
+			Type       string            `json:"type"`
+			Attributes *kibanaSavedQuery `json:"attributes"`
+			Query      json.RawMessage   `json:"query"`
+			Filters    json.RawMessage   `json:"filters"`
+		}{}
+		if err := json.Unmarshal(value, &object); err != nil {
+			return nil, err
+		}
+		switch {
+		case object.Attributes != nil && (object.Type == "" || object.Type == "query"):
+			saved = object.Attributes
+		case object.Attributes == nil && (object.Query != nil || object.Filters != nil):
+			if err := json.Unmarshal(value, saved); err != nil {
+				return nil, err
+			}
+		default:
+			//other saved objects or export summary
+			continue
+		}
+		result := &KibanaQuery{Language: saved.Query.Language, Filters: saved.Filters}
+		switch query := saved.Query.Query.(type) {
+		case string:
+			result.Query = query
+		case nil:
+		default:
+			queryJs, _ := json.Marshal(query)
+			return nil, fmt.Errorf("unsupported query in saved query: %s", queryJs)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("no saved query found")
+}
+
+// Translates enabled Kibana filters to queries. Phrase(s), range and exists filters are supported (negated or not),
+// filters of other types are reported and ignored.
+func kibanaFilterQueries(filters []kibanaFilter) []elastic.Query {
+	var queries []elastic.Query
+	for _, filter := range filters {
+		if filter.Meta.Disabled {
+			continue
+		}
+		query, err := filter.toQuery()
+		if err != nil {
+			Error.Printf("Ignoring imported filter: %s\n", err)
+			continue
+		}
+		if filter.Meta.Negate {
+			query = elastic.NewBoolQuery().MustNot(query)
+		}
+		queries = append(queries, query)
+	}
+	return queries
+}
+
+func (filter kibanaFilter) toQuery() (elastic.Query, error) {
+	key := filter.Meta.Key
+	switch filter.Meta.Type {
+	case "phrase":
+		var params struct {
+			Query interface{} `json:"query"`
+		}
+		json.Unmarshal(filter.Meta.Params, &params)
+		if params.Query == nil {
+			//older Kibana versions only keep the value in the query
+			params.Query = filter.Query["match_phrase"][key]
+			if value, ok := params.Query.(map[string]interface{}); ok {
+				params.Query = value["query"]
+			}
+		}
+		if key == "" || params.Query == nil {
+			return nil, fmt.Errorf("phrase filter without field or value")
+		}
+		return elastic.NewMatchPhraseQuery(key, params.Query), nil
+	case "phrases":
+		var params []interface{}
+		if err := json.Unmarshal(filter.Meta.Params, &params); err != nil || key == "" || len(params) == 0 {
+			return nil, fmt.Errorf("phrases filter without field or values")
+		}
+		query := elastic.NewBoolQuery().MinimumNumberShouldMatch(1)
+		for _, value := range params {
+			query = query.Should(elastic.NewMatchPhraseQuery(key, value))
+		}
+		return query, nil
+	case "range":
+		bounds := filter.Range[key]
+		if bounds == nil {
+			bounds, _ = filter.Query["range"][key].(map[string]interface{})
+		}
+		if bounds == nil {
+			json.Unmarshal(filter.Meta.Params, &bounds)
+		}
+		if key == "" || len(bounds) == 0 {
+			return nil, fmt.Errorf("range filter without field or bounds")
+		}
+		query := elastic.NewRangeQuery(key)
+		for operator, value := range bounds {
+			switch operator {
+			case "gte":
+				query = query.Gte(value)
+			case "gt":
+				query = query.Gt(value)
+			case "lte":
+				query = query.Lte(value)
+			case "lt":
+				query = query.Lt(value)
+			case "format":
+				query = query.Format(fmt.Sprint(value))
+			}
+		}
+		return query, nil
+	case "exists":
+		field, _ := filter.Exists["field"].(string)
+		if field == "" {
+			field, _ = filter.Query["exists"]["field"].(string)
+		}
+		if field == "" {
+			field = key
+		}
+		if field == "" {
+			return nil, fmt.Errorf("exists filter without field")
+		}
+		return elastic.NewExistsQuery(field), nil
+	}
+	return nil, fmt.Errorf("%s filter on %s is not supported", filter.Meta.Type, key)
+}
@@ -0,0 +1,184 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	configuration "github.com/piersharding/elktail/configuration"
+)
+
+// NamedQuery is one of the queries tailed side by side (--queries-file). Query is combined with the query given on
+// the command line, index and format override the configured ones if given.
+type NamedQuery struct {
+	Name   string `json:"name"`
+	Query  string `json:"query"`
+	Index  string `json:"index"`
+	Format string `json:"format"`
+	Color  string `json:"color"`
+}
+
+// LoadNamedQueries reads the JSON array of named queries from the file
+func LoadNamedQueries(path string) ([]NamedQuery, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseNamedQueries(data)
+}
+
+func parseNamedQueries(data []byte) ([]NamedQuery, error) {
+	var queries []NamedQuery
+	if err := json.Unmarshal(data, &queries); err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no queries defined")
+	}
+	names := map[string]bool{}
+	for _, query := range queries {
+		if query.Name == "" {
+			return nil, fmt.Errorf("query %q has no name", query.Query)
+		}
+		if names[query.Name] {
+			return nil, fmt.Errorf("query name %s is used more than once", query.Name)
+		}
+		names[query.Name] = true
+		if query.Color != "" && labelColors[query.Color] == "" {
+			return nil, fmt.Errorf("color %s of query %s is not one of %s", query.Color, query.Name, labelColorNames())
+		}
+	}
+	return queries, nil
+}
+
+func labelColorNames() []string {
+	names := make([]string, 0, len(labelColors))
+	for name := range labelColors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MultiTail tails several named queries and prints their entries in one stream, merged by timestamp and labeled
+// with the name of the query
+type MultiTail struct {
+	tails []*Tail
+}
+
+// NewMultiTail creates a Tail for each of the queries. Tails share the output, so lines are not interleaved.
+func NewMultiTail(config *configuration.Configuration, queries []NamedQuery) *MultiTail {
+	multi := new(MultiTail)
+	for i, query := range queries {
+		queryConfig := *config
+		queryConfig.QueryDefinition.Terms = append([]string(nil), config.QueryDefinition.Terms...)
+		if query.Query != "" {
+			queryConfig.QueryDefinition.Terms = andTerms(queryConfig.QueryDefinition.Terms, []string{query.Query})
+		}
+		if query.Index != "" {
+			queryConfig.SearchTarget.IndexPattern = query.Index
+		}
+		if query.Format != "" {
+			queryConfig.QueryDefinition.Format = query.Format
+		}
+		if i > 0 {
+			//output file is opened once and shared with the first tail
+			queryConfig.OutputFile = ""
+		}
+		tail := NewTail(&queryConfig)
+		tail.label = formatLabel(query.Name, query.Color, tail.color)
+		tail.queryName = query.Name
+		if i > 0 {
+			tail.output = multi.tails[0].output
+			tail.sinks = multi.tails[0].sinks
+		}
+		multi.tails = append(multi.tails, tail)
+	}
+	return multi
+}
+
+// Start lists the last entries of each query and, if following, polls all of them for new entries
+func (multi *MultiTail) Start(follow bool, initialEntries int) {
+	for _, tail := range multi.tails {
+		tail.prepare(follow)
+		if follow && tail.tailOnly {
+			tail.lastTimeStamp = tail.formatTimeStamp(time.Now().UTC())
+		}
+	}
+	if !follow || !multi.tails[0].tailOnly {
+		multi.processResults(multi.search(func(tail *Tail) (*elastic.SearchResult, error) {
+			return tail.initialSearch(initialEntries)
+		}))
+	}
+	delay := 500 * time.Millisecond
+	for iteration := 0; follow && !multi.tails[0].isLastIteration(iteration); iteration++ {
+		time.Sleep(delay)
+		results := multi.search(func(tail *Tail) (*elastic.SearchResult, error) {
+			return tail.poll(initialEntries)
+		})
+		multi.processResults(results)
+		found := false
+		for _, result := range results {
+			found = found || result.TotalHits() > 0
+		}
+		delay = nextPollDelay(delay, found)
+	}
+	for _, tail := range multi.tails {
+		tail.finishRepeatedLines()
+	}
+	//sinks are shared by all the tails
+	multi.tails[0].closeSinks()
+}
+
+// Runs the search of each query
+func (multi *MultiTail) search(search func(tail *Tail) (*elastic.SearchResult, error)) []*elastic.SearchResult {
+	results := make([]*elastic.SearchResult, len(multi.tails))
+	for i, tail := range multi.tails {
+		var err error
+		results[i], err = search(tail)
+		if err != nil {
+			Error.Fatalln("Error in executing search query.", describeSearchError(err))
+		}
+	}
+	return results
+}
+
+// Prints the hits of all the queries merged by timestamp, each by the tail of its query
+func (multi *MultiTail) processResults(results []*elastic.SearchResult) {
+	lists := make([][]*elastic.SearchHit, len(results))
+	owners := map[*elastic.SearchHit]*Tail{}
+	total := 0
+	for i, result := range results {
+		tail := multi.tails[i]
+		Trace.Printf("Fetched page of %d results out of %d total.\n", len(result.Hits.Hits), result.TotalHits())
+		lists[i] = tail.orderedHits(result.Hits.Hits)
+		for _, hit := range lists[i] {
+			owners[hit] = tail
+		}
+		total += len(lists[i])
+	}
+	for _, hit := range mergeHits(lists, true, total) {
+		tail := owners[hit]
+		tail.processResultHit(hit, tail.isRawFastPath())
+	}
+	for _, tail := range multi.tails {
+		tail.finishResults()
+	}
+}
+
+// PrintedEntries returns the number of entries printed for all the queries
+func (multi *MultiTail) PrintedEntries() int64 {
+	var printed int64
+	for _, tail := range multi.tails {
+		printed += tail.printedEntries
+	}
+	return printed
+}
@@ -0,0 +1,153 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	outputText   = "text"
+	outputNDJSON = "ndjson"
+)
+
+// Key orders of structured output
+const (
+	fieldsOrderListed = "fields"
+	fieldsOrderSorted = "sorted"
+)
+
+// Checks that output mode is one of the supported ones
+func validateOutput(output string) error {
+	switch output {
+	case outputText, outputNDJSON, "":
+		return nil
+	}
+	return fmt.Errorf("%s is not one of %s or %s", output, outputText, outputNDJSON)
+}
+
+// Checks that fields order is one of the supported ones
+func validateFieldsOrder(order string) error {
+	switch order {
+	case fieldsOrderListed, fieldsOrderSorted, "":
+		return nil
+	}
+	return fmt.Errorf("%s is not one of %s or %s", order, fieldsOrderListed, fieldsOrderSorted)
+}
+
+// Parses --fields values, which may be repeated or given as comma separated list
+func parseFieldList(values []string) []string {
+	var fields []string
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// Flattens nested objects of the entry into a single level map with dot separated keys, e.g.
+// {"log":{"level":"INFO"}} becomes {"log.level":"INFO"}. Arrays are kept as they are.
+func flattenEntry(entry map[string]interface{}) map[string]interface{} {
+	flat := map[string]interface{}{}
+	flattenInto(flat, "", entry)
+	return flat
+}
+
+func flattenInto(flat map[string]interface{}, prefix string, value map[string]interface{}) {
+	for key, child := range value {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if childMap, ok := child.(map[string]interface{}); ok && len(childMap) > 0 {
+			flattenInto(flat, key, childMap)
+		} else {
+			flat[key] = child
+		}
+	}
+}
+
+// Restricts the flattened entry to the given fields. A field also selects all the fields nested under it,
+// e.g. "log" selects "log.level" and "log.logger".
+func projectFields(flat map[string]interface{}, fields []string) map[string]interface{} {
+	if len(fields) == 0 {
+		return flat
+	}
+	projected := map[string]interface{}{}
+	for key, value := range flat {
+		for _, field := range fields {
+			if key == field || strings.HasPrefix(key, field+".") {
+				projected[key] = value
+				break
+			}
+		}
+	}
+	return projected
+}
+
+// Renders the entry as a single line of flattened JSON, restricted to the fields. Keys are in the order the fields
+// are listed in if listedOrder is set (keys selected by the same field sorted), otherwise sorted, so the same entry
+// always renders to the same bytes.
+func formatNDJSON(entry map[string]interface{}, fields []string, listedOrder bool) (string, error) {
+	projected := projectFields(flattenEntry(entry), fields)
+	if !listedOrder || len(fields) == 0 {
+		line, err := json.Marshal(projected)
+		if err != nil {
+			return "", err
+		}
+		return string(line), nil
+	}
+	var line bytes.Buffer
+	line.WriteByte('{')
+	for i, key := range orderKeys(projected, fields) {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		keyJs, err := json.Marshal(key)
+		if err != nil {
+			return "", err
+		}
+		valueJs, err := json.Marshal(projected[key])
+		if err != nil {
+			return "", err
+		}
+		line.Write(keyJs)
+		line.WriteByte(':')
+		line.Write(valueJs)
+	}
+	line.WriteByte('}')
+	return line.String(), nil
+}
+
+// Orders keys of the projected entry by the first of the fields selecting them, then alphabetically
+func orderKeys(projected map[string]interface{}, fields []string) []string {
+	rank := func(key string) int {
+		for i, field := range fields {
+			if key == field || strings.HasPrefix(key, field+".") {
+				return i
+			}
+		}
+		return len(fields)
+	}
+	keys := make([]string, 0, len(projected))
+	for key := range projected {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		rankI, rankJ := rank(keys[i]), rank(keys[j])
+		if rankI != rankJ {
+			return rankI < rankJ
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
@@ -0,0 +1,222 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Maximum length of sample values shown next to fields in the picker
+const pickerValueWidth = 50
+
+// PickFields fetches the latest entry matching the query and lets user pick fields from its flattened field list
+// in a terminal UI.
+// Picked fields are used as ndjson --fields or, in text output, as format. The equivalent option is printed so it
+// can be reused.
+func (tail *Tail) PickFields(in io.Reader, out io.Writer) error {
+	//sample has to have all the fields, --auto-fields projection is recomputed for the picked format
+	autoFields := tail.sourceFields != nil
+	tail.sourceFields = nil
+	result, err := tail.executeSearch(tail.indices, tail.buildInitialSearchRequest(1))
+	if err != nil {
+		return err
+	}
+	if len(result.Hits.Hits) == 0 {
+		return fmt.Errorf("no entries to pick fields from")
+	}
+	hit := result.Hits.Hits[0]
+	var entry map[string]interface{}
+	if tail.useFieldsAPI {
+		entry = fieldsEntry(hit.Fields)
+	} else if entry, err = parseEntry(hit.Source); err != nil {
+		return err
+	}
+	picked, err := pickFields(flattenEntry(entry), in, out)
+	if err != nil {
+		return err
+	}
+	if tail.ndjson {
+		tail.fields = picked
+		fmt.Fprintf(out, "Using --fields %s\n", strings.Join(picked, ","))
+	} else {
+		tail.queryDefinition.Format = "%" + strings.Join(picked, " %")
+		fmt.Fprintf(out, "Using --format '%s'\n", tail.queryDefinition.Format)
+		if autoFields {
+			tail.sourceFields = tail.autoSourceFields()
+		}
+	}
+	return nil
+}
+
+// fieldPicker lists the fields of a sample entry with their values and lets user pick them with keys: Up/Down (or
+// k/j) move the cursor, Space picks or unpicks the field under it, Enter confirms and Ctrl-C cancels. Fields are
+// picked in the order they were picked in, which is the order they are shown in.
+type fieldPicker struct {
+	fields    []string
+	values    []string   //sample values shown next to the fields
+	picked    []int      //indexes of the picked fields, in the order they were picked
+	cursor    int        //index of the field under the cursor
+	top       int        //index of the first field on the screen
+	escape    []byte     //escape sequence of an arrow key being read
+	cancelled bool       //picking was cancelled rather than confirmed
+	out       io.Writer  //terminal the picker is drawn to
+	height    func() int //rows of the terminal
+}
+
+func newFieldPicker(sample map[string]interface{}, out io.Writer, height func() int) *fieldPicker {
+	picker := &fieldPicker{fields: make([]string, 0, len(sample)), out: out, height: height}
+	for field := range sample {
+		picker.fields = append(picker.fields, field)
+	}
+	sort.Strings(picker.fields)
+	for _, field := range picker.fields {
+		value := fmt.Sprintf("%v", sample[field])
+		if len(value) > pickerValueWidth {
+			value = value[:pickerValueWidth-3] + "..."
+		}
+		picker.values = append(picker.values, value)
+	}
+	return picker
+}
+
+// Reads the keys until fields are picked. If in is a terminal, it's switched to raw mode meanwhile, so keys are
+// read as they are typed.
+func pickFields(sample map[string]interface{}, in io.Reader, out io.Writer) ([]string, error) {
+	height := func() int { return 24 }
+	if file, ok := in.(*os.File); ok {
+		fd := int(file.Fd())
+		if !terminal.IsTerminal(fd) {
+			return nil, fmt.Errorf("field picker needs a terminal")
+		}
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			return nil, err
+		}
+		defer terminal.Restore(fd, state)
+	}
+	if file, ok := out.(*os.File); ok {
+		height = func() int {
+			_, height, err := terminal.GetSize(int(file.Fd()))
+			if err != nil || height < 2 {
+				return 24
+			}
+			return height
+		}
+	}
+	picker := newFieldPicker(sample, out, height)
+	picker.draw()
+	defer picker.close()
+	reader := bufio.NewReader(in)
+	for {
+		key, err := reader.ReadByte()
+		if err != nil || !picker.handleKey(key) {
+			break
+		}
+	}
+	if picker.cancelled || len(picker.picked) == 0 {
+		return nil, fmt.Errorf("no fields picked")
+	}
+	picked := make([]string, len(picker.picked))
+	for i, index := range picker.picked {
+		picked[i] = picker.fields[index]
+	}
+	return picked, nil
+}
+
+// Moves the cursor or picks fields with the key, returns false if picking ended
+func (picker *fieldPicker) handleKey(key byte) bool {
+	//arrow keys are sent as escape sequences, e.g. ESC [ A for Up
+	if key == keyEscape || len(picker.escape) > 0 {
+		picker.escape = append(picker.escape, key)
+		if len(picker.escape) < 3 {
+			return true
+		}
+		key = map[string]byte{"\033[A": 'k', "\033[B": 'j'}[string(picker.escape)]
+		picker.escape = nil
+	}
+	switch key {
+	case keyCtrlC, keyCtrlD, 'q':
+		picker.cancelled = true
+		return false
+	case '\r', '\n':
+		if len(picker.picked) == 0 {
+			picker.toggle(picker.cursor)
+		}
+		return false
+	case ' ':
+		picker.toggle(picker.cursor)
+	case 'k':
+		if picker.cursor > 0 {
+			picker.cursor--
+		}
+	case 'j':
+		if picker.cursor < len(picker.fields)-1 {
+			picker.cursor++
+		}
+	}
+	picker.draw()
+	return true
+}
+
+// Picks the field, or unpicks it if it's already picked
+func (picker *fieldPicker) toggle(index int) {
+	for i, picked := range picker.picked {
+		if picked == index {
+			picker.picked = append(picker.picked[:i], picker.picked[i+1:]...)
+			return
+		}
+	}
+	picker.picked = append(picker.picked, index)
+}
+
+// Returns the position of the field among the picked ones (starting at 1), 0 if it's not picked
+func (picker *fieldPicker) position(index int) int {
+	for i, picked := range picker.picked {
+		if picked == index {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Redraws the screen: the fields scrolled to keep the cursor visible, followed by the status line. Line wrapping is
+// turned off, so each field takes a single row.
+func (picker *fieldPicker) draw() {
+	rows := picker.height() - 1
+	if picker.cursor < picker.top {
+		picker.top = picker.cursor
+	} else if picker.cursor >= picker.top+rows {
+		picker.top = picker.cursor - rows + 1
+	}
+	var screen strings.Builder
+	screen.WriteString("\033[?7l\033[H\033[2J")
+	for index := picker.top; index < len(picker.fields) && index < picker.top+rows; index++ {
+		mark := "   "
+		if position := picker.position(index); position > 0 {
+			mark = fmt.Sprintf("%2d.", position)
+		}
+		line := fmt.Sprintf("[%s] %-40s %s", mark, picker.fields[index], picker.values[index])
+		if index == picker.cursor {
+			line = "\033[7m" + line + "\033[27m"
+		}
+		screen.WriteString(line + "\r\n")
+	}
+	fmt.Fprintf(&screen, "\033[%d;1H\033[7m %d/%d fields picked \033[27m (Up/Down moves, Space picks, Enter confirms, "+
+		"Ctrl-C cancels)", rows+1, len(picker.picked), len(picker.fields))
+	io.WriteString(picker.out, screen.String())
+}
+
+// Clears the screen and turns line wrapping back on
+func (picker *fieldPicker) close() {
+	io.WriteString(picker.out, "\033[?7h\033[H\033[2J")
+}
@@ -0,0 +1,82 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// Remembers hashes of the last printed lines, so that lines printed again when the search is reissued (e.g. after
+// reconnecting) are suppressed. Unlike the ID based dedup of follow queries, it works on the rendered output, so it
+// holds across searches. Oldest hashes are evicted once the capacity is reached.
+type lineHistory struct {
+	hashes []uint64
+	seen   map[uint64]bool
+	next   int
+}
+
+func newLineHistory(capacity int) *lineHistory {
+	return &lineHistory{hashes: make([]uint64, 0, capacity), seen: make(map[uint64]bool, capacity)}
+}
+
+// Records the line, returning false if it is one of the remembered lines
+func (history *lineHistory) add(line string) bool {
+	hash := fnv.New64a()
+	hash.Write([]byte(line))
+	sum := hash.Sum64()
+	if history.seen[sum] {
+		return false
+	}
+	if len(history.hashes) < cap(history.hashes) {
+		history.hashes = append(history.hashes, sum)
+	} else {
+		delete(history.seen, history.hashes[history.next])
+		history.hashes[history.next] = sum
+		history.next = (history.next + 1) % len(history.hashes)
+	}
+	history.seen[sum] = true
+	return true
+}
+
+// Collapses runs of identical consecutive lines, like uniq. Optionally the end of the run is summarized with the
+// number of suppressed repetitions.
+type repeatedLines struct {
+	last    string
+	started bool
+	repeats int
+	summary bool
+}
+
+func newRepeatedLines(summary bool) *repeatedLines {
+	return &repeatedLines{summary: summary}
+}
+
+// Records the line, returning true if it repeats the previous line. When a run of repeated lines ends, its summary
+// is returned as well (empty if there's none to print).
+func (run *repeatedLines) add(line string) (bool, string) {
+	if run.started && line == run.last {
+		run.repeats++
+		return true, ""
+	}
+	summary := run.end()
+	run.last = line
+	run.started = true
+	return false, summary
+}
+
+// Ends the current run, returning its summary, e.g. "... (repeated 3 times)"
+func (run *repeatedLines) end() string {
+	repeats := run.repeats
+	run.repeats = 0
+	if !run.summary || repeats == 0 {
+		return ""
+	}
+	if repeats == 1 {
+		return "... (repeated 1 time)"
+	}
+	return "... (repeated " + strconv.Itoa(repeats) + " times)"
+}
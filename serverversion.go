@@ -0,0 +1,101 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/olivere/elastic/v7"
+	"golang.org/x/net/context"
+)
+
+const distributionOpenSearch = "opensearch"
+
+// Version of the search server as reported by its root endpoint
+type serverVersion struct {
+	Distribution string `json:"distribution"`
+	Number       string `json:"number"`
+}
+
+// Detects the version of the server, which is either elasticsearch or opensearch
+func (tail *Tail) detectServerVersion() (*serverVersion, error) {
+	response, err := tail.client.PerformRequest(context.TODO(), elastic.PerformRequestOptions{
+		Method: "GET",
+		Path:   "/",
+	})
+	if err != nil {
+		return nil, err
+	}
+	var root struct {
+		Version serverVersion `json:"version"`
+	}
+	if err := json.Unmarshal(response.Body, &root); err != nil {
+		return nil, err
+	}
+	if root.Version.Number == "" {
+		return nil, fmt.Errorf("server did not report its version")
+	}
+	return &root.Version, nil
+}
+
+// CheckVersion warns (without failing) when the server version is outside of the range elktail is known to work
+// with, hinting at the features that may not work
+func (tail *Tail) CheckVersion() {
+	version, err := tail.detectServerVersion()
+	if err != nil {
+		Info.Println("Could not detect server version.", err)
+		return
+	}
+	Info.Printf("Server is %s %s\n", version.name(), version.Number)
+	for _, warning := range versionWarnings(version) {
+		Error.Printf("Warning: %s\n", warning)
+	}
+}
+
+func (version *serverVersion) name() string {
+	if version.Distribution == distributionOpenSearch {
+		return "OpenSearch"
+	}
+	return "Elasticsearch"
+}
+
+// Returns major and minor version numbers (0 if they can't be parsed)
+func (version *serverVersion) majorMinor() (int, int) {
+	parts := strings.SplitN(version.Number, ".", 3)
+	major, _ := strconv.Atoi(parts[0])
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	return major, minor
+}
+
+// Returns warnings for server versions outside of the supported range: Elasticsearch 7.x and OpenSearch 1.x and 2.x
+func versionWarnings(version *serverVersion) []string {
+	major, minor := version.majorMinor()
+	unsupported := fmt.Sprintf("%s %s is not supported by elktail", version.name(), version.Number)
+	if version.Distribution == distributionOpenSearch {
+		if major < 1 || major > 2 {
+			return []string{unsupported + " (supported versions are 1.x and 2.x)."}
+		}
+		return []string{"OpenSearch does not support runtime fields (--runtime-field). Kibana authentication " +
+			"cookie flow (-u with Kibana URL) does not work with OpenSearch Dashboards."}
+	}
+	switch {
+	case major < 7:
+		return []string{unsupported + " (supported versions are 7.x). Searches are likely to fail."}
+	case major > 7:
+		return []string{unsupported + " (supported versions are 7.x). It is used through the 7.x compatible API, " +
+			"Kibana authentication cookie flow (-u with Kibana URL) does not work with Kibana 8."}
+	case minor < 11:
+		return []string{fmt.Sprintf("Runtime fields (--runtime-field) require Elasticsearch 7.11 or newer, "+
+			"server is %s.", version.Number)}
+	}
+	return nil
+}
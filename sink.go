@@ -0,0 +1,77 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// outputSink receives each printed entry in addition to the console output, e.g. to tee entries to a file.
+// Entry is the parsed source (nil in raw list-only mode, where sources are not parsed), source is the raw
+// JSON source with renames applied.
+type outputSink interface {
+	write(hit *elastic.SearchHit, entry map[string]interface{}, source []byte) error
+	// Called after each batch of results
+	flush() error
+	// Called once after the last batch of results
+	close() error
+}
+
+// Writes sources of entries as compact JSON objects, one per line
+type jsonLinesSink struct {
+	writer *bufio.Writer
+	file   *os.File //file the sink created and closes (nil if writing elsewhere)
+}
+
+func newJSONLinesSink(writer io.Writer) *jsonLinesSink {
+	return &jsonLinesSink{writer: bufio.NewWriter(writer)}
+}
+
+// Creates (or truncates) the file given with --output-file
+func newFileSink(path string) (*jsonLinesSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	sink := newJSONLinesSink(file)
+	sink.file = file
+	return sink, nil
+}
+
+func (sink *jsonLinesSink) write(hit *elastic.SearchHit, entry map[string]interface{}, source []byte) error {
+	var line bytes.Buffer
+	if err := json.Compact(&line, source); err != nil {
+		return err
+	}
+	line.WriteByte('\n')
+	_, err := sink.writer.Write(line.Bytes())
+	return err
+}
+
+func (sink *jsonLinesSink) flush() error {
+	return sink.writer.Flush()
+}
+
+// Flushes the entries and, if the sink created the file, syncs and closes it, so entries are known to be written
+func (sink *jsonLinesSink) close() error {
+	err := sink.flush()
+	if sink.file == nil {
+		return err
+	}
+	if syncErr := sink.file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := sink.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
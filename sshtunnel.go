@@ -15,6 +15,7 @@ import (
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	"io"
+	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
@@ -22,6 +23,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type Endpoint struct {
@@ -39,50 +42,162 @@ type SSHTunnel struct {
 	Remote *Endpoint
 
 	Config *ssh.ClientConfig
+
+	//Interval of keepalive requests used to detect a dead SSH connection, 0 disables them
+	KeepAlive time.Duration
+
+	//Called after the SSH connection is re-established with the number of attempts it took and the error of the
+	//last failed one (nil if the first attempt succeeded)
+	OnReconnect func(attempts int, err error)
+
+	mutex  sync.Mutex
+	client *ssh.Client
 }
 
+//Maximum wait between attempts to re-establish the SSH connection
+const maxReconnectBackoff = 30 * time.Second
+
+//Maximum wait for the SSH server to accept a connection
+const sshDialTimeout = 15 * time.Second
+
 func (tunnel *SSHTunnel) Start() error {
 	listener, err := net.Listen("tcp", tunnel.Local.String())
 	if err != nil {
 		Error.Printf("SSH Tunnel: Failed to start server at %s. Error: %s", tunnel.Local.String(), err)
 		return err
 	}
-	defer listener.Close()
 
+	if _, err := tunnel.connect(); err != nil {
+		listener.Close()
+		Error.Fatalf("SSH Tunnel: %s\n", err)
+		return err
+	}
+	if tunnel.KeepAlive > 0 {
+		go tunnel.keepAlive()
+	}
+	return tunnel.serve(listener)
+}
+
+// Forwards connections accepted by the listener through the tunnel until the listener fails
+func (tunnel *SSHTunnel) serve(listener net.Listener) error {
+	defer listener.Close()
 	for {
-		serverConn, err := ssh.Dial("tcp", tunnel.Server.String(), tunnel.Config)
-		if err != nil {
-			Error.Fatalf("SSH Tunnel: %s\n", err)
-			return err
-		}
 		conn, err := listener.Accept()
 		if err != nil {
 			Error.Printf("SSH Tunnel: Failed to accept connection: %s", err)
 			return err
 		}
 		Info.Print("SSH Tunnel: Accepted connection to forward to the tunnel...")
-		go tunnel.forward(conn, serverConn)
+		go tunnel.forward(conn)
 	}
 }
 
-func (tunnel *SSHTunnel) forward(localConn net.Conn, sshServerConn *ssh.Client) {
-	/*
-		serverConn, err := ssh.Dial("tcp", tunnel.Server.String(), tunnel.Config)
+// Returns the current SSH connection, dialing the server if there is none
+func (tunnel *SSHTunnel) connect() (*ssh.Client, error) {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	if tunnel.client != nil {
+		return tunnel.client, nil
+	}
+	client, err := ssh.Dial("tcp", tunnel.Server.String(), tunnel.Config)
+	if err != nil {
+		return nil, err
+	}
+	tunnel.client = client
+	return client, nil
+}
+
+// Re-establishes the SSH connection, retrying with increasing backoff until it succeeds. Connections to the
+// tunnel wait meanwhile, which pauses the tailing until the tunnel is back.
+func (tunnel *SSHTunnel) reconnect(dead *ssh.Client) *ssh.Client {
+	tunnel.mutex.Lock()
+	//dead is nil when connecting failed, there's no connection to close then
+	lost := dead != nil && tunnel.client == dead
+	if lost {
+		tunnel.client = nil
+		dead.Close()
+		Info.Printf("SSH Tunnel: Connection to %s lost, reconnecting...", tunnel.Server.String())
+	}
+	tunnel.mutex.Unlock()
+
+	backoff := time.Second
+	var lastErr error
+	for attempts := 1; ; attempts++ {
+		client, err := tunnel.connect()
+		if err == nil {
+			Info.Printf("SSH Tunnel: Connected to %s", tunnel.Server.String())
+			//connection re-established by another caller in the meantime is not a reconnect of this one
+			if (lost || lastErr != nil) && tunnel.OnReconnect != nil {
+				tunnel.OnReconnect(attempts, lastErr)
+			}
+			return client
+		}
+		lastErr = err
+		Error.Printf("SSH Tunnel: Reconnecting failed, retrying in %s: %s", backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// Periodically sends keepalive requests over the SSH connection and reconnects when they fail or the server
+// doesn't reply within the keepalive interval
+func (tunnel *SSHTunnel) keepAlive() {
+	for range time.Tick(tunnel.KeepAlive) {
+		client, err := tunnel.connect()
 		if err != nil {
-			Error.Fatalf("SSH Tunnel: Server dial error: %s\n", err)
-			return
-		}*/
+			tunnel.reconnect(nil)
+			continue
+		}
+		if err := sendKeepAlive(client, tunnel.KeepAlive); err != nil {
+			Trace.Printf("SSH Tunnel: Keepalive failed: %s", err)
+			tunnel.reconnect(client)
+		}
+	}
+}
+
+// Sends a keepalive request and waits at most timeout for the reply
+func sendKeepAlive(client *ssh.Client, timeout time.Duration) error {
+	replied := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		replied <- err
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-replied:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("no reply within %s", timeout)
+	}
+}
+
+func (tunnel *SSHTunnel) forward(localConn net.Conn) {
+	sshServerConn, err := tunnel.connect()
+	if err != nil {
+		sshServerConn = tunnel.reconnect(nil)
+	}
 
 	remoteConn, err := sshServerConn.Dial("tcp", tunnel.Remote.String())
+	if _, rejected := err.(*ssh.OpenChannelError); err != nil && !rejected {
+		//the connection may have died since the last keepalive, try once more on a fresh one. A rejected channel
+		//means the connection is fine, but the server can't reach the remote end.
+		Trace.Printf("SSH Tunnel: Remote dial error: %s\n", err)
+		remoteConn, err = tunnel.reconnect(sshServerConn).Dial("tcp", tunnel.Remote.String())
+	}
 	if err != nil {
-		Error.Fatalf("SSH Tunnel: Remote dial error: %s\n", err)
+		Error.Printf("SSH Tunnel: Remote dial error: %s\n", err)
+		localConn.Close()
 		return
 	}
 
 	copyConn := func(writer, reader net.Conn) {
+		defer writer.Close()
 		_, err := io.Copy(writer, reader)
 		if err != nil {
-			Error.Fatalf("SSH Tunnel: Could not forward conenction: %s\n", err)
+			Trace.Printf("SSH Tunnel: Forwarding connection ended: %s\n", err)
 		}
 	}
 
@@ -97,6 +212,19 @@ func SSHAgent() ssh.AuthMethod {
 	return nil
 }
 
+// PrivateKeyFile returns auth method using the (unencrypted) private key in the given file
+func PrivateKeyFile(path string) (ssh.AuthMethod, error) {
+	key, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
 func GetUser() (string, error) {
 	//attempt fetching logged in user via os/user package. It may not work when cross-compiled due to CGO requirement.
 	//More info at: https://github.com/golang/go/issues/11797
@@ -214,6 +342,7 @@ func NewSSHTunnel(sshUser string, sshHost string, sshPort int, localPort int,
 			ssh.PasswordCallback(passwordCallback),
 		},
 		HostKeyCallback: ssh.HostKeyCallback(hostKeyCallback),
+		Timeout:         sshDialTimeout,
 	}
 
 	return &SSHTunnel{
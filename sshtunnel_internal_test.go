@@ -6,7 +6,12 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"github.com/piersharding/elktail/testutils"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
 	"os"
 	"testing"
 )
@@ -33,3 +38,84 @@ func TestNewSSHTunnelFromHostStrings(t *testing.T) {
 	testutils.AssertEqualsInt(t, tunnel.Server.Port, 22)
 
 }
+
+// Starts an SSH server accepting any client, returning its endpoint
+func startTestSSHServer(t *testing.T) *Endpoint {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for channel := range channels {
+					channel.Reject(ssh.Prohibited, "")
+				}
+			}()
+		}
+	}()
+	address := listener.Addr().(*net.TCPAddr)
+	return &Endpoint{Host: address.IP.String(), Port: address.Port}
+}
+
+func TestReconnectWithoutConnection(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	reconnected := false
+	tunnel := &SSHTunnel{
+		Server:      startTestSSHServer(t),
+		Config:      &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()},
+		OnReconnect: func(attempts int, err error) { reconnected = true },
+	}
+	//as called by keepAlive and forward when connecting failed, before any connection was made
+	client := tunnel.reconnect(nil)
+	if client == nil || tunnel.client != client {
+		testutils.Fail(t, "Expected reconnect to connect the tunnel")
+	}
+	if reconnected {
+		testutils.Fail(t, "Expected first connection not to be reported as a reconnect")
+	}
+	client.Close()
+}
+
+func TestForwardRejectedChannel(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	reconnected := false
+	tunnel := &SSHTunnel{
+		Server:      startTestSSHServer(t),
+		Remote:      &Endpoint{Host: "localhost", Port: 9200},
+		Config:      &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()},
+		OnReconnect: func(attempts int, err error) { reconnected = true },
+	}
+	client, err := tunnel.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	//the server rejects the channel, which says nothing about the health of the connection
+	local, remote := net.Pipe()
+	defer remote.Close()
+	tunnel.forward(local)
+	if tunnel.client != client || reconnected {
+		testutils.Fail(t, "Expected rejected channel not to reconnect the tunnel")
+	}
+}
@@ -0,0 +1,84 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// throughputStats accumulates the entries and bytes fetched in follow mode and periodically prints them
+// (--stats-interval), to show the log volume of the query while tailing
+type throughputStats struct {
+	mutex         sync.Mutex
+	interval      time.Duration
+	entries       int64         //entries fetched since start
+	bytes         int64         //bytes of entry sources fetched since start
+	delay         time.Duration //current delay between polls
+	reported      time.Time     //when the stats were last printed
+	reportedCount int64         //entries fetched as of the last report
+}
+
+func newThroughputStats(interval time.Duration) *throughputStats {
+	return &throughputStats{interval: interval, reported: time.Now()}
+}
+
+// Records a page of fetched entries
+func (stats *throughputStats) add(entries int, bytes int) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	stats.entries += int64(entries)
+	stats.bytes += int64(bytes)
+}
+
+// Records the delay before the next poll
+func (stats *throughputStats) setDelay(delay time.Duration) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+	stats.delay = delay
+}
+
+// Prints the stats line to out every interval
+func (stats *throughputStats) run(out io.Writer) {
+	ticker := time.NewTicker(stats.interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		stats.mutex.Lock()
+		message := stats.message(now)
+		stats.reported = now
+		stats.reportedCount = stats.entries
+		stats.mutex.Unlock()
+		fmt.Fprintln(out, message)
+	}
+}
+
+// Formats the stats line, e.g. "Stats: 12.5 entries/s, 1250 entries total, poll delay 1s, 2.4 MB processed".
+// Rate is the one since the last report, so it follows changes of the volume.
+func (stats *throughputStats) message(now time.Time) string {
+	rate := 0.0
+	if elapsed := now.Sub(stats.reported); elapsed > 0 {
+		rate = float64(stats.entries-stats.reportedCount) / elapsed.Seconds()
+	}
+	return fmt.Sprintf("Stats: %.1f entries/s, %d entries total, poll delay %s, %s processed", rate, stats.entries,
+		stats.delay, formatBytes(stats.bytes))
+}
+
+// Formats the byte count with a binary unit, e.g. "1.5 KB"
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes) / unit
+	units := "KMGTPE"
+	i := 0
+	for ; value >= unit && i < len(units)-1; i++ {
+		value /= unit
+	}
+	return fmt.Sprintf("%.1f %cB", value, units[i])
+}
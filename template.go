@@ -0,0 +1,100 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// How often template file is checked for changes when watching it
+const templateWatchInterval = time.Second
+
+// Functions available in output templates
+var templateFuncs = template.FuncMap{
+	//field evaluates dot syntax expression (e.g. "log.level") on the entry, empty if the field is missing
+	"field": func(entry map[string]interface{}, expression string) string {
+		value, _ := EvaluateExpression(entry, expression)
+		return value
+	},
+}
+
+// Go template entries are rendered with, given inline or loaded from a file (which can be watched for changes)
+type entryTemplate struct {
+	template  *template.Template
+	path      string    //template file, empty for inline template
+	watch     bool      //reload template when the file changes
+	modTime   time.Time //modification time of the loaded template file
+	lastCheck time.Time //when the file was last checked for changes
+}
+
+func newInlineTemplate(text string) (*entryTemplate, error) {
+	parsed, err := template.New("template").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &entryTemplate{template: parsed}, nil
+}
+
+// Loads template from the file. Template is named by the file, so parse and execution errors point at the file and
+// line, e.g. template: format.tmpl:3: function "bogus" not defined
+func loadTemplateFile(path string, watch bool) (*entryTemplate, error) {
+	entryTemplate := &entryTemplate{path: path, watch: watch}
+	if err := entryTemplate.load(); err != nil {
+		return nil, err
+	}
+	return entryTemplate, nil
+}
+
+func (t *entryTemplate) load() error {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return err
+	}
+	text, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return err
+	}
+	parsed, err := template.New(info.Name()).Funcs(templateFuncs).Parse(string(text))
+	if err != nil {
+		return err
+	}
+	t.template = parsed
+	t.modTime = info.ModTime()
+	return nil
+}
+
+// Reloads the template file if it changed since it was loaded. If the changed template can't be parsed, error is
+// reported and the previous template is kept.
+func (t *entryTemplate) reloadIfChanged() {
+	if !t.watch || time.Since(t.lastCheck) < templateWatchInterval {
+		return
+	}
+	t.lastCheck = time.Now()
+	info, err := os.Stat(t.path)
+	if err != nil || !info.ModTime().After(t.modTime) {
+		return
+	}
+	if err := t.load(); err != nil {
+		Error.Printf("Failed reloading template, keeping the previous one: %s\n", err)
+		t.modTime = info.ModTime()
+		return
+	}
+	Info.Printf("Reloaded template %s\n", t.path)
+}
+
+// Renders the entry, trailing newline is trimmed as each entry is printed on its own line(s)
+func (t *entryTemplate) render(entry map[string]interface{}) (string, error) {
+	t.reloadIfChanged()
+	var result strings.Builder
+	if err := t.template.Execute(&result, entry); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(result.String(), "\n"), nil
+}
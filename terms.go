@@ -0,0 +1,93 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ReadTermsFile reads query terms from the file, one per line. Blank lines and lines starting with # are skipped.
+func ReadTermsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readTerms(file)
+}
+
+func readTerms(reader io.Reader) ([]string, error) {
+	var terms []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		term := strings.TrimSpace(scanner.Text())
+		if term == "" || strings.HasPrefix(term, "#") {
+			continue
+		}
+		terms = append(terms, term)
+	}
+	return terms, scanner.Err()
+}
+
+// Operators applied between saved query terms and additional terms (--combine)
+const (
+	combineAnd = "and"
+	combineOr  = "or"
+)
+
+// Combines the saved query terms with the terms given on the command line. A single saved term is replaced by the
+// given terms. With or, both sides are parenthesized, as AND binds tighter than OR in query strings.
+func combineTerms(saved []string, terms []string, operator string) ([]string, error) {
+	switch operator {
+	case combineAnd, "":
+		if len(saved) > 1 {
+			return append(append(append([]string(nil), saved...), "AND"), terms...), nil
+		}
+	case combineOr:
+		if len(saved) > 1 {
+			return []string{"(" + strings.Join(saved, " ") + ")", "OR", "(" + strings.Join(terms, " ") + ")"}, nil
+		}
+	default:
+		return nil, fmt.Errorf("%s is not one of %s or %s", operator, combineAnd, combineOr)
+	}
+	return append([]string(nil), terms...), nil
+}
+
+// Appends the terms to the query terms, so that entries have to match all of them. Each term is parenthesized, so
+// operators within it don't bind to the other terms.
+func andTerms(queryTerms []string, terms []string) []string {
+	for _, term := range terms {
+		if len(queryTerms) > 0 {
+			queryTerms = append(queryTerms, "AND")
+		}
+		queryTerms = append(queryTerms, "("+term+")")
+	}
+	return queryTerms
+}
+
+// Reads the query string piped to stdin (query argument -). Lines of a multiline query are joined with spaces.
+func readQuery(reader io.Reader) (string, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	query := strings.Join(lines, " ")
+	if query == "" {
+		return "", fmt.Errorf("query read from stdin is empty")
+	}
+	return query, nil
+}
@@ -0,0 +1,52 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layout of the unix preset, rendered as seconds since epoch rather than with time.Format
+const unixTimeLayout = "unix"
+
+// Named --time-layout presets, other values are used as Go reference time layouts
+var timeLayoutPresets = map[string]string{
+	"kitchen":     time.Kitchen,
+	"stamp":       time.StampMilli,
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"unix":        unixTimeLayout,
+}
+
+// Resolves --time-layout to the layout used for displayed timestamps. Layouts without any reference time element
+// would render every timestamp as the same constant string, so they are rejected.
+func resolveTimeLayout(layout string) (string, error) {
+	if preset, ok := timeLayoutPresets[strings.ToLower(layout)]; ok {
+		return preset, nil
+	}
+	if time.Unix(0, 0).Format(layout) == layout {
+		return "", fmt.Errorf("%s is neither a preset (kitchen, stamp, rfc3339, rfc3339nano, unix) nor a layout "+
+			"using Go reference time, e.g. 15:04:05", layout)
+	}
+	return layout, nil
+}
+
+// Renders the entry timestamp in local time using the layout. Values that are not RFC3339 timestamps are returned
+// unchanged.
+func formatDisplayTimeStamp(value string, layout string) string {
+	timeStamp, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return value
+	}
+	timeStamp = timeStamp.Local()
+	if layout == unixTimeLayout {
+		return strconv.FormatInt(timeStamp.Unix(), 10)
+	}
+	return timeStamp.Format(layout)
+}
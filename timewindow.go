@@ -0,0 +1,63 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+)
+
+// Recurring window of the day excluded from results, in minutes after midnight UTC. Window ends before start if it
+// spans midnight, e.g. 22:00-02:00.
+type timeOfDayWindow struct {
+	start int
+	end   int
+}
+
+// Keeps entries whose timestamp is outside of the window. Timestamps are date values in UTC, so the window is in UTC
+// as well. Entries without timestamp are kept.
+const excludeTimeScript = `
+if (doc[params.field].size() == 0) {
+  return true;
+}
+ZonedDateTime timeStamp = doc[params.field].value;
+int minute = timeStamp.getHour() * 60 + timeStamp.getMinute();
+if (params.start <= params.end) {
+  return minute < params.start || minute >= params.end;
+}
+return minute < params.start && minute >= params.end;
+`
+
+// Parses the window in HH:MM-HH:MM format, e.g. 02:00-03:00
+func parseTimeOfDayWindow(window string) (*timeOfDayWindow, error) {
+	bounds := strings.Split(window, "-")
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("%s is not a window in HH:MM-HH:MM format", window)
+	}
+	var minutes [2]int
+	for i, bound := range bounds {
+		parsed, err := time.Parse("15:04", strings.TrimSpace(bound))
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a time of day in HH:MM format", bound)
+		}
+		minutes[i] = parsed.Hour()*60 + parsed.Minute()
+	}
+	if minutes[0] == minutes[1] {
+		return nil, fmt.Errorf("window %s is empty", window)
+	}
+	return &timeOfDayWindow{start: minutes[0], end: minutes[1]}, nil
+}
+
+// Builds script filter excluding entries with timestamp within the window
+func (window *timeOfDayWindow) buildFilter(timestampField string) elastic.Query {
+	script := elastic.NewScript(excludeTimeScript).
+		Lang("painless").
+		Params(map[string]interface{}{"field": timestampField, "start": window.start, "end": window.end})
+	return elastic.NewScriptQuery(script)
+}
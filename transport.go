@@ -0,0 +1,244 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	configuration "github.com/piersharding/elktail/configuration"
+	"golang.org/x/net/context"
+)
+
+const opaqueIdHeader = "X-Opaque-Id"
+
+const (
+	opaqueIdSession = "session"
+	opaqueIdRequest = "request"
+)
+
+// Returns the function generating X-Opaque-Id header values, which elasticsearch includes in its task, slow and
+// deprecation logs. In session mode all requests carry the same id, which is logged so it can be looked up. In
+// request mode ids share a random part and are numbered, so the random id is only generated once, here.
+// Returns nil if mode is empty.
+func newOpaqueIdGenerator(mode string, prefix string) (func() string, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case opaqueIdSession, opaqueIdRequest:
+	default:
+		return nil, fmt.Errorf("unknown mode %s, expected %s or %s", mode, opaqueIdSession, opaqueIdRequest)
+	}
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return nil, fmt.Errorf("failed generating random id: %s", err)
+	}
+	id := prefix + hex.EncodeToString(random)
+	if mode == opaqueIdSession {
+		Info.Printf("Tagging requests with %s: %s\n", opaqueIdHeader, id)
+		return func() string { return id }, nil
+	}
+	var sequence int64
+	return func() string { return fmt.Sprintf("%s-%d", id, atomic.AddInt64(&sequence, 1)) }, nil
+}
+
+// Creates HTTP transport with the settings of the default transport (proxy from environment, timeouts), connection
+// pooling configured for frequent polling of a single host and the TLS configuration, if any
+func newHTTPTransport(config *configuration.Configuration, tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = config.MaxIdleConns
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = config.IdleConnTimeout
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport
+}
+
+// Creates the single HTTP client used for all requests: Kibana decorator wrapping the (optionally size limited and
+// dumped) transport, which carries the TLS configuration, e.g. client certificate for mutual TLS
+func newHTTPClient(config *configuration.Configuration, tlsConfig *tls.Config, decorator KibanaDecorator) *http.Client {
+	var transport http.RoundTripper = newHTTPTransport(config, tlsConfig)
+	if config.MaxResponseBytes > 0 {
+		transport = ResponseSizeLimiter{r: transport, maxBytes: config.MaxResponseBytes}
+	}
+	if config.DumpResponses != "" {
+		transport = newResponseDumper(transport, config.DumpResponses)
+	}
+	decorator.r = transport
+	return &http.Client{Transport: decorator}
+}
+
+// ResponseSizeLimiter is a round tripper that fails reading of response bodies bigger than maxBytes, so a huge
+// response results in a clear error instead of being parsed into memory
+type ResponseSizeLimiter struct {
+	r        http.RoundTripper
+	maxBytes int64
+}
+
+func (limiter ResponseSizeLimiter) RoundTrip(r *http.Request) (*http.Response, error) {
+	response, err := limiter.r.RoundTrip(r)
+	if err != nil {
+		return response, err
+	}
+	if response.ContentLength > limiter.maxBytes {
+		response.Body.Close()
+		return nil, responseTooLargeError(limiter.maxBytes)
+	}
+	response.Body = &limitedBody{body: response.Body, limit: limiter.maxBytes}
+	return response, nil
+}
+
+type limitedBody struct {
+	body  io.ReadCloser
+	limit int64
+	read  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n, responseTooLargeError(b.limit)
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	return b.body.Close()
+}
+
+func responseTooLargeError(maxBytes int64) error {
+	return fmt.Errorf("response is larger than %d bytes, use --max-response-bytes to raise the limit or reduce -n", maxBytes)
+}
+
+// ResponseDumper is a round tripper that writes a copy of each response body to its own file in the directory
+// (--dump-responses), as the client reads it, so responses of a specific cluster can be inspected or replayed
+type ResponseDumper struct {
+	r         http.RoundTripper
+	directory string
+	count     *int64
+}
+
+func newResponseDumper(r http.RoundTripper, directory string) ResponseDumper {
+	return ResponseDumper{r: r, directory: directory, count: new(int64)}
+}
+
+func (dumper ResponseDumper) RoundTrip(r *http.Request) (*http.Response, error) {
+	response, err := dumper.r.RoundTrip(r)
+	if err != nil {
+		return response, err
+	}
+	if err := os.MkdirAll(dumper.directory, 0700); err != nil {
+		Error.Println("Failed creating --dump-responses directory.", err)
+		return response, nil
+	}
+	path := filepath.Join(dumper.directory, dumpFileName(time.Now(), atomic.AddInt64(dumper.count, 1)))
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		Error.Println("Failed creating response dump.", err)
+		return response, nil
+	}
+	Trace.Printf("Dumping response of %s %s to %s\n", r.Method, r.URL.Path, path)
+	response.Body = &dumpedBody{Reader: io.TeeReader(response.Body, file), body: response.Body, file: file}
+	return response, nil
+}
+
+// Names dump files so they sort in the order the responses were received, e.g. 20220101T101500.123-0001.json
+func dumpFileName(received time.Time, sequence int64) string {
+	return fmt.Sprintf("%s-%04d.json", received.UTC().Format("20060102T150405.000"), sequence)
+}
+
+type dumpedBody struct {
+	io.Reader
+	body io.Closer
+	file *os.File
+}
+
+func (b *dumpedBody) Close() error {
+	b.file.Close()
+	return b.body.Close()
+}
+
+// Marks responses after which the decorator logged in again, only these are worth retrying when unauthorized.
+// It's set on the response received by the client, never sent to the server.
+const reauthenticatedHeader = "X-Elktail-Reauthenticated"
+
+// Status codes of responses retried by statusRetrier
+var retriedStatusCodes = []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests,
+	http.StatusServiceUnavailable}
+
+const maxThrottledRetries = 5
+const maxThrottledBackoff = 30 * time.Second
+
+// Returns the retrier of requests elasticsearch rejected with one of retriedStatusCodes. Throttled requests (429, 503)
+// are retried with increasing backoff, or after Retry-After if the server asks for longer. Unauthorized requests
+// (401, 403) are retried once if the decorator logged in again after the session cookie was rejected, otherwise the
+// retry would be rejected the same way. Requests failing for other reasons are not retried.
+func newStatusRetrier() elastic.Retrier {
+	return elastic.RetrierFunc(func(ctx context.Context, retry int, request *http.Request, response *http.Response,
+		err error) (time.Duration, bool, error) {
+		if response == nil {
+			return 0, false, nil
+		}
+		var wait time.Duration
+		switch response.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			if retry > 1 || response.Header.Get(reauthenticatedHeader) == "" {
+				return 0, false, nil
+			}
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			if retry > maxThrottledRetries {
+				return 0, false, nil
+			}
+			wait = throttledBackoff(retry, response.Header.Get("Retry-After"))
+			Info.Printf("ElasticSearch is throttling requests (%s), retrying in %s\n", response.Status, wait)
+		default:
+			return 0, false, nil
+		}
+		response.Body.Close()
+		return wait, true, nil
+	})
+}
+
+// Returns the wait before the retry: 500ms doubled with each retry up to maxThrottledBackoff, or Retry-After seconds
+// if they are longer
+func throttledBackoff(retry int, retryAfter string) time.Duration {
+	wait := maxThrottledBackoff
+	if retry <= 6 {
+		wait = 500 * time.Millisecond << uint(retry-1)
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && time.Duration(seconds)*time.Second > wait {
+		wait = time.Duration(seconds) * time.Second
+	}
+	return wait
+}
+
+// Returns an actionable hint for errors with the status codes handled in the transport, empty for other errors
+func describeStatusError(err error) string {
+	switch {
+	case elastic.IsUnauthorized(err):
+		return "Authentication was rejected. Check the credentials (-u, --password-file), the --header values and, " +
+			"with Kibana, the --auth-cookie-file."
+	case elastic.IsForbidden(err):
+		return "User is not allowed to search the indices. Check the roles of the user (or --run-as user) and the " +
+			"index pattern."
+	case elastic.IsStatusCode(err, http.StatusTooManyRequests), elastic.IsStatusCode(err, http.StatusServiceUnavailable):
+		return "ElasticSearch kept rejecting requests as overloaded or unavailable after retrying. Try again later, " +
+			"narrow down the query or lower --batch-size."
+	}
+	return ""
+}
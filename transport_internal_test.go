@@ -0,0 +1,315 @@
+/* Copyright (C) 2022 Piers Harding
+ *
+ * This software may be modified and distributed under the terms
+ * of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olivere/elastic/v7"
+	configuration "github.com/piersharding/elktail/configuration"
+	tu "github.com/piersharding/elktail/testutils"
+	"golang.org/x/net/context"
+)
+
+func TestLimitedBody(t *testing.T) {
+	body := &limitedBody{body: ioutil.NopCloser(strings.NewReader("0123456789")), limit: 10}
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		tu.Fail(t, "Expected body within limit to be read without error")
+	}
+	tu.AssertEqualsString(t, "0123456789", string(content))
+
+	body = &limitedBody{body: ioutil.NopCloser(strings.NewReader("0123456789")), limit: 5}
+	_, err = ioutil.ReadAll(body)
+	if err == nil {
+		tu.Fail(t, "Expected error reading body over limit")
+	}
+}
+
+// Points HOME to a temporary directory with saved auth cookie, so decorator doesn't try to authenticate
+func withAuthCookie(t *testing.T, token string) {
+	home, err := ioutil.TempDir("", "elktail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(home) })
+	t.Setenv("HOME", home)
+	if err := os.Mkdir(filepath.Join(home, confDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(home, confDir, "auth.cookie"), []byte(token), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKibanaDecoratorCatIndices(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	withAuthCookie(t, "token")
+
+	//secured cluster which rejects requests without credentials, cookie and the required header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		cookie, err := r.Cookie("sid-auth")
+		if !ok || user != "elastic" || password != "secret" || err != nil || cookie.Value != "token" ||
+			r.Header.Get("X-Tenant") != "ops" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"index":"logs-2022.01.01"}]`))
+	}))
+	defer server.Close()
+
+	config := &configuration.Configuration{User: "elastic", Password: "secret"}
+	decorator := KibanaDecorator{r: http.DefaultTransport, extraHeaders: map[string]string{"X-Tenant": "ops"},
+		configuration: config}
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false),
+		elastic.SetHttpClient(&http.Client{Transport: decorator}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	indices, err := client.CatIndices().Do(context.TODO())
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsInt(t, 1, len(indices))
+	tu.AssertEqualsString(t, "logs-2022.01.01", indices[0].Index)
+}
+
+func TestKibanaDecoratorOpaqueId(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	withAuthCookie(t, "token")
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.Header.Get(opaqueIdHeader))
+	}))
+	defer server.Close()
+
+	for _, mode := range []string{opaqueIdSession, opaqueIdRequest} {
+		ids = nil
+		opaqueId, err := newOpaqueIdGenerator(mode, "ops-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: KibanaDecorator{r: http.DefaultTransport, opaqueId: opaqueId,
+			configuration: &configuration.Configuration{}}}
+		for i := 0; i < 2; i++ {
+			response, err := client.Get(server.URL + "/_cat/indices")
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+		}
+		tu.AssertEqualsInt(t, 2, len(ids))
+		if !strings.HasPrefix(ids[0], "ops-") || (ids[0] == ids[1]) != (mode == opaqueIdSession) {
+			tu.Fail(t, "Unexpected "+mode+" ids "+strings.Join(ids, ", "))
+		}
+	}
+	//request ids are numbered after the random part generated once
+	if !strings.HasSuffix(ids[0], "-1") || strings.TrimSuffix(ids[0], "-1")+"-2" != ids[1] {
+		tu.Fail(t, "Expected numbered request ids, got "+strings.Join(ids, ", "))
+	}
+	if _, err := newOpaqueIdGenerator("always", ""); err == nil {
+		tu.Fail(t, "Expected unknown mode to be rejected")
+	}
+}
+
+func TestLoadTLSConfigMinVersion(t *testing.T) {
+	config := &configuration.Configuration{}
+	tlsConfig, err := loadTLSConfig(config)
+	if err != nil || tlsConfig != nil {
+		tu.Fail(t, "Expected no TLS configuration by default")
+	}
+	config.SearchTarget.TLSMinVersion = "1.3"
+	tlsConfig, err = loadTLSConfig(config)
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsInt(t, tls.VersionTLS13, int(tlsConfig.MinVersion))
+	config.SearchTarget.TLSMinVersion = "1.1"
+	if _, err = loadTLSConfig(config); err == nil {
+		tu.Fail(t, "Expected unsupported TLS version to be rejected")
+	}
+}
+
+func TestNewHTTPClientKeepsTLSConfig(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	withAuthCookie(t, "token")
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("sid-auth"); err != nil || cookie.Value != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	//server certificate is self signed, so the request only succeeds if the TLS configuration is used
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	tlsConfig := &tls.Config{RootCAs: rootCAs}
+	config := &configuration.Configuration{MaxResponseBytes: 1024}
+	client := newHTTPClient(config, tlsConfig, KibanaDecorator{configuration: config})
+
+	limiter := client.Transport.(KibanaDecorator).r.(ResponseSizeLimiter)
+	if limiter.r.(*http.Transport).TLSClientConfig != tlsConfig {
+		tu.Fail(t, "Expected transport to use the TLS configuration")
+	}
+	response, err := client.Get(server.URL + "/_cat/indices")
+	if err != nil {
+		tu.Fail(t, err.Error())
+	}
+	defer response.Body.Close()
+	tu.AssertEqualsInt(t, http.StatusOK, response.StatusCode)
+}
+
+func TestStatusRetrier(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	withAuthCookie(t, "expired")
+	statuses := []int{http.StatusUnauthorized, http.StatusOK}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "sid-auth", Value: "token"})
+			return
+		}
+		status := statuses[requests]
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	//decorator logs in again when the session cookie is rejected, so the request is retried with the new one
+	config := &configuration.Configuration{}
+	config.SearchTarget.Url = server.URL
+	httpClient := &http.Client{Transport: KibanaDecorator{r: http.DefaultTransport, configuration: config}}
+	client, err := elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false),
+		elastic.SetRetrier(newStatusRetrier()), elastic.SetRetryStatusCodes(retriedStatusCodes...),
+		elastic.SetHttpClient(httpClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CatIndices().Do(context.TODO()); err != nil {
+		tu.Fail(t, err.Error())
+	}
+	tu.AssertEqualsInt(t, 2, requests)
+
+	//unauthorized requests are only retried once
+	statuses, requests = []int{http.StatusForbidden, http.StatusForbidden, http.StatusOK}, 0
+	_, err = client.CatIndices().Do(context.TODO())
+	tu.AssertEqualsInt(t, 2, requests)
+	if !strings.Contains(describeSearchError(err), "Check the roles") {
+		tu.Fail(t, "Expected hint about roles, got "+describeSearchError(err))
+	}
+
+	//without logging in again, the retry would be rejected as well
+	client, err = elastic.NewClient(elastic.SetURL(server.URL), elastic.SetSniff(false), elastic.SetHealthcheck(false),
+		elastic.SetRetrier(newStatusRetrier()), elastic.SetRetryStatusCodes(retriedStatusCodes...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, requests = []int{http.StatusUnauthorized, http.StatusOK}, 0
+	if _, err := client.CatIndices().Do(context.TODO()); !elastic.IsUnauthorized(err) {
+		tu.Fail(t, "Expected unauthorized request not to be retried")
+	}
+	tu.AssertEqualsInt(t, 1, requests)
+
+	throttled := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"3"}},
+		Body: ioutil.NopCloser(strings.NewReader(""))}
+	wait, ok, _ := newStatusRetrier().Retry(context.TODO(), 2, nil, throttled, nil)
+	if !ok || wait != 3*time.Second {
+		tu.Fail(t, "Expected throttled request to be retried after 3s, got "+wait.String())
+	}
+	if _, ok, _ := newStatusRetrier().Retry(context.TODO(), maxThrottledRetries+1, nil, throttled, nil); ok {
+		tu.Fail(t, "Expected throttled request not to be retried any more")
+	}
+}
+
+func TestThrottledBackoff(t *testing.T) {
+	tu.AssertEqualsString(t, "500ms", throttledBackoff(1, "").String())
+	tu.AssertEqualsString(t, "2s", throttledBackoff(3, "1").String())
+	tu.AssertEqualsString(t, "10s", throttledBackoff(3, "10").String())
+	tu.AssertEqualsString(t, "30s", throttledBackoff(20, "").String())
+}
+
+func TestResponseDumper(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"took":1}`))
+	}))
+	defer server.Close()
+	directory, err := ioutil.TempDir("", "elktail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	client := &http.Client{Transport: newResponseDumper(http.DefaultTransport, filepath.Join(directory, "dumps"))}
+	for i := 0; i < 2; i++ {
+		response, err := client.Get(server.URL + "/_search")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		tu.AssertEqualsString(t, `{"took":1}`, string(body))
+	}
+	files, err := filepath.Glob(filepath.Join(directory, "dumps", "*-000[12].json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.AssertEqualsInt(t, 2, len(files))
+	dumped, _ := ioutil.ReadFile(files[1])
+	tu.AssertEqualsString(t, `{"took":1}`, string(dumped))
+	tu.AssertEqualsString(t, "20220101T101500.123-0007.json",
+		dumpFileName(time.Date(2022, 1, 1, 10, 15, 0, 123000000, time.UTC), 7))
+}
+
+func TestFetchSavedSearchUsesTransport(t *testing.T) {
+	InitLogging(ioutil.Discard, ioutil.Discard, ioutil.Discard, false)
+	withAuthCookie(t, "token")
+	//secured Kibana which rejects requests without credentials, cookie, run-as and the required header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		cookie, err := r.Cookie("sid-auth")
+		if !ok || user != "elastic" || password != "secret" || err != nil || cookie.Value != "token" ||
+			r.Header.Get("X-Tenant") != "ops" || r.Header.Get(runAsHeader) != "reader" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/saved_objects/search/errors":
+			w.Write([]byte(`{"attributes":{"title":"Errors","kibanaSavedObjectMeta":{"searchSourceJSON":` +
+				`"{\"query\":{\"query\":\"level:ERROR\",\"language\":\"lucene\"},\"indexRefName\":\"ref\"}"}},` +
+				`"references":[{"name":"ref","type":"index-pattern","id":"logs"}]}`))
+		case "/api/saved_objects/index-pattern/logs":
+			w.Write([]byte(`{"attributes":{"title":"logs-*"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := &configuration.Configuration{User: "elastic", Password: "secret", RunAs: "reader"}
+	config.SearchTarget.Url = server.URL
+	config.SearchTarget.ExtraHeaders = []string{"X-Tenant: ops"}
+	search, err := FetchSavedSearch(config, "errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu.AssertEqualsString(t, "logs-*", search.IndexPattern)
+	tu.AssertEqualsString(t, "level:ERROR", search.Query)
+}
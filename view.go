@@ -0,0 +1,166 @@
+/*  Copyright (C) 2022 Piers Harding
+ *
+ *  This software may be modified and distributed under the terms
+ *  of the MIT license. See the LICENSE file for details.
+ */
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyBackspace = 8
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// rollingView keeps the last rendered output lines in memory and shows those matching a filter typed by the user,
+// so a broad stream can be narrowed down live without querying elasticsearch again. Rendered output is written
+// to the view (it's an io.Writer), so lines look the same as when printed.
+type rollingView struct {
+	mutex   sync.Mutex
+	lines   []string   //ring buffer of the last lines
+	next    int        //position of the next line in the ring buffer, once it's full
+	pending []byte     //last line written, until it's terminated
+	filter  string     //case insensitive substring visible lines contain
+	out     io.Writer  //terminal the view is drawn to
+	height  func() int //rows of the terminal
+}
+
+func newRollingView(capacity int, out io.Writer, height func() int) *rollingView {
+	return &rollingView{lines: make([]string, 0, capacity), out: out, height: height}
+}
+
+// StartRollingView switches the terminal to raw mode and shows the output in a rolling view of the last lines.
+// Keys typed edit the filter, Ctrl-C or Ctrl-D restores the terminal and exits.
+func (tail *Tail) StartRollingView(lines int) error {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !terminal.IsTerminal(in) || !terminal.IsTerminal(out) {
+		return fmt.Errorf("rolling view needs a terminal")
+	}
+	state, err := terminal.MakeRaw(in)
+	if err != nil {
+		return err
+	}
+	view := newRollingView(lines, os.Stdout, func() int {
+		_, height, err := terminal.GetSize(out)
+		if err != nil || height < 2 {
+			return 24
+		}
+		return height
+	})
+	tail.output = bufio.NewWriter(view)
+	view.draw()
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			key, err := reader.ReadByte()
+			if err != nil || !view.handleKey(key) {
+				view.close()
+				terminal.Restore(in, state)
+				os.Exit(0)
+			}
+		}
+	}()
+	return nil
+}
+
+// Write adds the complete lines to the view and redraws it
+func (view *rollingView) Write(p []byte) (int, error) {
+	view.mutex.Lock()
+	view.pending = append(view.pending, p...)
+	for {
+		end := bytes.IndexByte(view.pending, '\n')
+		if end == -1 {
+			break
+		}
+		view.add(string(view.pending[:end]))
+		view.pending = view.pending[end+1:]
+	}
+	view.mutex.Unlock()
+	view.draw()
+	return len(p), nil
+}
+
+// Adds the line to the ring buffer, replacing the oldest line once it's full
+func (view *rollingView) add(line string) {
+	if len(view.lines) < cap(view.lines) {
+		view.lines = append(view.lines, line)
+		return
+	}
+	view.lines[view.next] = line
+	view.next = (view.next + 1) % len(view.lines)
+}
+
+// Returns the last count lines matching the filter, oldest first, and the number of all matching lines
+func (view *rollingView) visibleLines(count int) ([]string, int) {
+	filter := strings.ToLower(view.filter)
+	var matching []string
+	for i := range view.lines {
+		line := view.lines[(view.next+i)%len(view.lines)]
+		if strings.Contains(strings.ToLower(line), filter) {
+			matching = append(matching, line)
+		}
+	}
+	if len(matching) > count {
+		return matching[len(matching)-count:], len(matching)
+	}
+	return matching, len(matching)
+}
+
+// Edits the filter with the key, returns false if the view should be closed
+func (view *rollingView) handleKey(key byte) bool {
+	view.mutex.Lock()
+	switch {
+	case key == keyCtrlC || key == keyCtrlD:
+		view.mutex.Unlock()
+		return false
+	case key == keyBackspace || key == keyDelete:
+		if len(view.filter) > 0 {
+			view.filter = view.filter[:len(view.filter)-1]
+		}
+	case key == keyCtrlU || key == keyEscape:
+		view.filter = ""
+	case key >= ' ' && key < keyDelete:
+		view.filter += string(key)
+	}
+	view.mutex.Unlock()
+	view.draw()
+	return true
+}
+
+// Redraws the screen: visible lines followed by the status line with the filter. Line wrapping is turned off, so
+// each line takes a single row.
+func (view *rollingView) draw() {
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+	height := view.height()
+	lines, matching := view.visibleLines(height - 1)
+	var screen strings.Builder
+	screen.WriteString("\033[?7l\033[H\033[2J")
+	for _, line := range lines {
+		screen.WriteString(line + ansiReset + "\r\n")
+	}
+	fmt.Fprintf(&screen, "\033[%d;1H\033[7m filter: %s \033[27m %d/%d lines (Ctrl-U clears, Ctrl-C quits)",
+		height, view.filter, matching, len(view.lines))
+	io.WriteString(view.out, screen.String())
+}
+
+// Clears the screen and turns line wrapping back on
+func (view *rollingView) close() {
+	view.mutex.Lock()
+	defer view.mutex.Unlock()
+	io.WriteString(view.out, "\033[?7h\033[H\033[2J")
+}